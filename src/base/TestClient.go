@@ -22,6 +22,7 @@ package base
 // 	if err != nil {
 // 		log.Println(err)
 // 	}
+// 	log.Println(client.Wait())
 
 // }
 
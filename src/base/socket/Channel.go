@@ -1,48 +1,386 @@
 package socket
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
-	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrChannelClosed    = errors.New("连接已关闭。")
+	ErrSeqUnsupported   = errors.New("编码解码器不支持请求序号，请使用NewSequencedCodecFactory。")
+	ErrFrameUnsupported = errors.New("连接不支持直接写入数据帧。")
+	ErrFrameChannelKey  = errors.New("连接设置了自己的密钥，不能写入共享的数据帧。")
+)
+
+var channelIdSeq uint64 // 连接id生成器
+
+const (
+	seqReplyFlag uint32 = 1 << 31 // 响应包的Seq最高位为1
+	seqMask      uint32 = seqReplyFlag - 1
 )
 
 type IChannel interface {
+	Id() uint64
 	Write(data interface{}) error
+	Send(msg interface{}) error
+	Call(ctx context.Context, req ProtoPack) (*ProtoPack, error)
+	Reply(req *ProtoPack, resp ProtoPack) error
 	SetAttribute(key string, val interface{})
 	GetAttribute(key string) (interface{}, bool)
+	PeerCertificates() []*x509.Certificate
+	RemoteAddr() net.Addr
 	Close() error
 	IsOpen() bool
 }
 
+// 编码解码器是否带请求序号
+type sequencedCodec interface {
+	Sequenced() bool
+}
+
+func isSequenced(codec ICodec) bool {
+	sequenced := false
+	walkCodec(codec, func(codec ICodec) bool {
+		if v, ok := codec.(sequencedCodec); ok {
+			sequenced = v.Sequenced()
+			return false
+		}
+		return true
+	})
+	return sequenced
+}
+
 type DefaultChannel struct {
-	codec      ICodec
-	socket     ITransport
-	attributes map[string]interface{}
+	id          uint64
+	lastRead    int64 // 最后一次读到数据的时间，UnixNano
+	lastWrite   int64 // 最后一次写出数据的时间，UnixNano
+	missedPongs int32 // 连续没有收到响应的心跳数
+	codec       ICodec
+	socket      ITransport
+	framed      ITransport // codec 使用的FramedTransport，用于直接写数据帧
+	writeLock   sync.Mutex
+	writeQueue  *writeQueue // 不为nil 时Write 只放入队列，由写协程写出
+	attrLock    sync.RWMutex
+	attributes  map[string]interface{}
+	messages    *MessageRegistry // Send 使用的消息注册表，nil 时使用DefaultMessageRegistry
+	serializer  Serializer       // 没有设置SerializerAttribute 时使用的序列化方式
+	remoteAddr  atomic.Value     // net.Addr，连接关闭后RemoteAddr 仍然可用
+	closeErr    atomic.Value     // *OpError，本地带原因关闭时记录，读协程用它代替ErrConnectionClosed
+
+	seq         uint32
+	pendingLock sync.Mutex
+	pending     map[uint32]chan *ProtoPack
+	closed      bool
 }
 
 func NewDefaultChannel(socket ITransport, codec ICodec) IChannel {
-	return &DefaultChannel{socket: socket, codec: codec, attributes: make(map[string]interface{})}
+	return newDefaultChannel(socket, nil, codec)
+}
+
+func newDefaultChannel(socket ITransport, framed ITransport, codec ICodec) *DefaultChannel {
+	now := time.Now().UnixNano()
+	channel := &DefaultChannel{
+		id:         atomic.AddUint64(&channelIdSeq, 1),
+		lastRead:   now,
+		lastWrite:  now,
+		socket:     socket,
+		framed:     framed,
+		codec:      codec,
+		attributes: make(map[string]interface{}),
+		pending:    make(map[uint32]chan *ProtoPack),
+	}
+	bindCodec(codec, channel)
+	return channel
+}
+
+// 连接id，进程内唯一
+func (channel *DefaultChannel) Id() uint64 {
+	return channel.id
 }
 
 func (channel *DefaultChannel) Write(data interface{}) error {
-	log.Println("")
 	if v, ok := data.(ProtoPack); ok {
+		if channel.writeQueue != nil {
+			return channel.writeQueue.put(writeItem{protoPack: v})
+		}
+		channel.writeLock.Lock()
 		err := channel.codec.Encode(v)
+		channel.writeLock.Unlock()
 		if err != nil {
-			return errors.New("发送数据失败。")
+			return classifyError("write", err)
+		}
+		if !isHeartbeat(v.Id) {
+			atomic.StoreInt64(&channel.lastWrite, time.Now().UnixNano())
+		}
+		return nil
+	}
+
+	return ErrInvalidData
+}
+
+/**
+ * 发送消息，按消息注册表填好Id 并编码Body
+ * @author abram
+ * @param msg 已注册的消息
+ */
+func (channel *DefaultChannel) Send(msg interface{}) error {
+	protoPack, err := channel.messageRegistry().MarshalWith(channel.Serializer(), msg)
+	if err != nil {
+		return err
+	}
+	return channel.Write(protoPack)
+}
+
+// 连接使用的序列化方式，SerializerAttribute 属性优先，其次是Config.Serializer，默认为protobuf
+func (channel *DefaultChannel) Serializer() Serializer {
+	if serializer := attributeSerializer(channel); serializer != nil {
+		return serializer
+	}
+	if channel.serializer != nil {
+		return channel.serializer
+	}
+	return ProtobufSerializer
+}
+
+func (channel *DefaultChannel) messageRegistry() *MessageRegistry {
+	if channel.messages == nil {
+		return DefaultMessageRegistry
+	}
+	return channel.messages
+}
+
+/**
+ * 直接写入EncodeFrame 编码好的数据帧，用于把同一个数据包发给多个连接。
+ * 设置了CipherKeyAttribute 的连接返回ErrFrameChannelKey，要用Write 单独编码
+ * @author abram
+ * @param frame 数据帧
+ */
+func (channel *DefaultChannel) WriteFrame(frame []byte) error {
+	if channel.framed == nil {
+		return ErrFrameUnsupported
+	}
+	if hasChannelKey(channel.codec) {
+		return ErrFrameChannelKey
+	}
+	if channel.writeQueue != nil {
+		return channel.writeQueue.put(writeItem{frame: frame})
+	}
+	channel.writeLock.Lock()
+	defer channel.writeLock.Unlock()
+	if _, err := channel.framed.Write(frame); err != nil {
+		return err
+	}
+	if err := channel.framed.Flush(); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&channel.lastWrite, time.Now().UnixNano())
+	return nil
+}
+
+/**
+ * 发送请求并等待对方用Reply返回的响应
+ * @author abram
+ * @param ctx 超时或取消时返回ctx.Err()
+ * @param req 请求包，Seq 会被覆盖
+ * @return 响应包
+ */
+func (channel *DefaultChannel) Call(ctx context.Context, req ProtoPack) (*ProtoPack, error) {
+	if !isSequenced(channel.codec) {
+		return nil, ErrSeqUnsupported
+	}
+
+	seq := atomic.AddUint32(&channel.seq, 1) & seqMask
+	for seq == 0 {
+		seq = atomic.AddUint32(&channel.seq, 1) & seqMask
+	}
+	req.Seq = seq
+
+	done := make(chan *ProtoPack, 1)
+	channel.pendingLock.Lock()
+	if channel.closed {
+		channel.pendingLock.Unlock()
+		return nil, ErrChannelClosed
+	}
+	channel.pending[seq] = done
+	channel.pendingLock.Unlock()
+
+	defer func() {
+		channel.pendingLock.Lock()
+		delete(channel.pending, seq)
+		channel.pendingLock.Unlock()
+	}()
+
+	if err := channel.Write(req); err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp, ok := <-done:
+		if !ok {
+			return nil, ErrChannelClosed
+		}
+		return resp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// 响应对方Call 发来的请求
+func (channel *DefaultChannel) Reply(req *ProtoPack, resp ProtoPack) error {
+	if req.Seq == 0 {
+		return errors.New("请求包没有Seq，不需要响应。")
+	}
+	resp.Seq = req.Seq | seqReplyFlag
+	return channel.Write(resp)
+}
+
+// 把响应包交给等待中的Call，是响应包时返回true
+func (channel *DefaultChannel) complete(protoPack *ProtoPack) bool {
+	if protoPack.Seq&seqReplyFlag == 0 {
+		return false
+	}
+	seq := protoPack.Seq & seqMask
+	protoPack.Seq = seq
+
+	channel.pendingLock.Lock()
+	done, ok := channel.pending[seq]
+	delete(channel.pending, seq)
+	channel.pendingLock.Unlock()
+	if ok {
+		done <- protoPack
+	}
+	return true
+}
+
+// 记录读到了数据，是心跳包时自动响应并返回true
+func (channel *DefaultChannel) received(protoPack *ProtoPack) bool {
+	atomic.StoreInt64(&channel.lastRead, time.Now().UnixNano())
+	switch protoPack.Id {
+	case HeartbeatPingId:
+		channel.Write(ProtoPack{Id: HeartbeatPongId, PlatformId: protoPack.PlatformId})
+		return true
+	case HeartbeatPongId:
+		atomic.StoreInt32(&channel.missedPongs, 0)
+		return true
+	}
+	return false
+}
+
+// 连接断开后让所有等待中的Call 返回ErrChannelClosed
+func (channel *DefaultChannel) failPending() {
+	channel.pendingLock.Lock()
+	defer channel.pendingLock.Unlock()
+	channel.closed = true
+	for seq, done := range channel.pending {
+		close(done)
+		delete(channel.pending, seq)
+	}
+}
+
+// 需要握手的传输层，比如TLS
+type handshaker interface {
+	Handshake() error
+}
+
+// 可以获取底层net.Conn 的传输层
+type connHolder interface {
+	Conn() net.Conn
+}
+
+// TLS 连接的状态，不是TLS 连接时返回false
+func (channel *DefaultChannel) TLSConnectionState() (tls.ConnectionState, bool) {
+	if v, ok := channel.socket.(connHolder); ok {
+		if conn, ok := v.Conn().(*tls.Conn); ok {
+			return conn.ConnectionState(), true
 		}
+	}
+	return tls.ConnectionState{}, false
+}
+
+// 对方的证书链，不是TLS 连接或对方没有提供证书时返回nil
+func (channel *DefaultChannel) PeerCertificates() []*x509.Certificate {
+	state, ok := channel.TLSConnectionState()
+	if !ok {
+		return nil
+	}
+	return state.PeerCertificates
+}
+
+// 对方的地址，经过负载均衡并启用了PROXY 协议时是真实的客户端地址
+func (channel *DefaultChannel) RemoteAddr() net.Addr {
+	if addr, ok := channel.remoteAddr.Load().(net.Addr); ok {
+		return addr
+	}
+	addr := transportRemoteAddr(channel.socket)
+	if addr != nil {
+		channel.remoteAddr.Store(addr)
+	}
+	return addr
+}
+
+// 连接开始时收到的PROXY 协议头，没有时返回nil
+func (channel *DefaultChannel) ProxyHeader() *ProxyHeader {
+	v, ok := channel.socket.(connHolder)
+	if !ok {
 		return nil
 	}
+	conn := v.Conn()
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if pc, ok := conn.(*proxyConn); ok {
+		return pc.header
+	}
+	return nil
+}
+
+// 可以只中断读操作的传输层
+type readInterrupter interface {
+	InterruptRead() error
+}
 
-	return errors.New("错误的数据。")
+// 停止读取新的数据，传输层不支持时直接关闭连接
+func (channel *DefaultChannel) interruptRead() error {
+	if v, ok := channel.socket.(readInterrupter); ok {
+		return v.InterruptRead()
+	}
+	return channel.Close()
 }
 
 // 关闭连接
 func (channel *DefaultChannel) Close() error {
+	if channel.writeQueue != nil {
+		// 丢弃还没写出的数据
+		channel.writeQueue.stop(false)
+	}
 	return channel.codec.Close()
 }
 
-//把缓存中的数据写出去，然后再关闭连接
+// 带原因关闭连接，读协程随后报告的是err 而不是ErrConnectionClosed
+func (channel *DefaultChannel) closeWith(err *OpError) error {
+	channel.closeErr.Store(err)
+	return channel.Close()
+}
+
+// 读协程结束时的错误，连接是带原因关闭的就换成关闭的原因
+func (channel *DefaultChannel) closeReason(err error) error {
+	if cause, ok := channel.closeErr.Load().(*OpError); ok && errors.Is(classifyError("read", err), ErrConnectionClosed) {
+		return cause
+	}
+	return err
+}
+
+// 把缓存中的数据写出去，然后再关闭连接
 func (channel *DefaultChannel) FlushAndClose() error {
+	if channel.writeQueue != nil {
+		channel.writeQueue.close(true)
+	}
 	return channel.codec.FlushAndClose()
 }
 
@@ -51,10 +389,14 @@ func (channel *DefaultChannel) IsOpen() bool {
 }
 
 func (channel *DefaultChannel) SetAttribute(key string, val interface{}) {
+	channel.attrLock.Lock()
+	defer channel.attrLock.Unlock()
 	channel.attributes[key] = val
 }
 
 func (channel *DefaultChannel) GetAttribute(key string) (interface{}, bool) {
+	channel.attrLock.RLock()
+	defer channel.attrLock.RUnlock()
 	v, ok := channel.attributes[key]
 	return v, ok
 }
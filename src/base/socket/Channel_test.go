@@ -0,0 +1,86 @@
+package socket
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// 获取一个空闲的本地地址
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// 测试用的配置，处理函数都是空的，测试里再替换需要的字段
+func newTestConfig(addr string) *Config {
+	config := NewConfig()
+	config.Addr = addr
+	config.CodecFactory = NewDefaultCodecFactory()
+	config.ConnectedHandler = func(channel IChannel) {}
+	config.DisconnectHandler = func(channel IChannel) {}
+	config.MessageHandler = func(channel IChannel, protoPack *ProtoPack) {}
+	return config
+}
+
+// 启动服务并等待监听成功
+func startTestServer(t *testing.T, config *Config) *Server {
+	server, err := NewServer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ready := server.Ready()
+	go server.Start()
+	select {
+	case <-ready:
+	case <-time.After(time.Second):
+		t.Fatal("server did not start")
+	}
+	return server
+}
+
+func TestChannelCall(t *testing.T) {
+	addr := freeAddr(t)
+	srvConfig := newTestConfig(addr)
+	srvConfig.CodecFactory = NewSequencedCodecFactory()
+	srvConfig.MessageHandler = func(channel IChannel, protoPack *ProtoPack) {
+		if protoPack.Id == 2 {
+			return // 不响应，让调用方超时
+		}
+		channel.Reply(protoPack, ProtoPack{Id: protoPack.Id, Body: append([]byte("re:"), protoPack.Body...)})
+	}
+	startTestServer(t, srvConfig)
+
+	connected := make(chan IChannel, 1)
+	cliConfig := newTestConfig(addr)
+	cliConfig.CodecFactory = NewSequencedCodecFactory()
+	cliConfig.ConnectedHandler = func(channel IChannel) { connected <- channel }
+	client, err := NewClient(cliConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go client.Open()
+	defer client.Close()
+	channel := <-connected
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	resp, err := channel.Call(ctx, ProtoPack{Id: 1, Body: []byte("hello")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Body) != "re:hello" || resp.Id != 1 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel2()
+	if _, err := channel.Call(ctx2, ProtoPack{Id: 2}); err != context.DeadlineExceeded {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+}
@@ -0,0 +1,297 @@
+package socket
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+var (
+	ErrTampered      = errors.New("数据包校验失败，可能被篡改。")
+	ErrNoCipherKey   = errors.New("连接没有设置密钥。")
+	ErrUnknownCipher = errors.New("未知的加密算法。")
+	ErrNotEncrypted  = errors.New("连接已经设置了密钥，不接受明文数据包。")
+)
+
+const (
+	AESGCM           = "aes-gcm"
+	ChaCha20Poly1305 = "chacha20-poly1305" // 没有AES 硬件加速的平台上更快
+
+	CipherKeyAttribute = "socket.cipherKey" // 连接密钥在IChannel 属性中的key，值为[]byte
+)
+
+// 根据密钥生成AEAD
+type AEADFactory func(key []byte) (cipher.AEAD, error)
+
+// 注册的加密算法
+type cipherEntry struct {
+	name    string
+	code    byte
+	newAEAD AEADFactory
+}
+
+var (
+	cipherLock  sync.RWMutex
+	ciphers     = make(map[string]*cipherEntry)
+	cipherCodes = make(map[byte]*cipherEntry)
+)
+
+/**
+ * 注册加密算法
+ * @author abram
+ * @param name 加密算法的名字
+ * @param code 写在ProtoPack.Isencrypted 中的编号，不能为0，通信双方要一致
+ * @param newAEAD 根据密钥生成AEAD 的函数
+ */
+func RegisterCipher(name string, code byte, newAEAD AEADFactory) {
+	if newAEAD == nil {
+		panic("socket:RegisterCipher newAEAD is nil")
+	}
+	if code == 0 {
+		panic("socket:RegisterCipher code 0 is reserved for plain bodies")
+	}
+	cipherLock.Lock()
+	defer cipherLock.Unlock()
+	if _, dup := ciphers[name]; dup {
+		panic("socket:RegisterCipher called twice for cipher " + name)
+	}
+	if _, dup := cipherCodes[code]; dup {
+		panic("socket:RegisterCipher called twice for code " + strconv.Itoa(int(code)))
+	}
+	entry := &cipherEntry{name: name, code: code, newAEAD: newAEAD}
+	ciphers[name] = entry
+	cipherCodes[code] = entry
+}
+
+func lookupCipher(name string) (*cipherEntry, bool) {
+	cipherLock.RLock()
+	defer cipherLock.RUnlock()
+	entry, ok := ciphers[name]
+	return entry, ok
+}
+
+func lookupCipherCode(code byte) (*cipherEntry, bool) {
+	cipherLock.RLock()
+	defer cipherLock.RUnlock()
+	entry, ok := cipherCodes[code]
+	return entry, ok
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func init() {
+	RegisterCipher(AESGCM, 1, newAESGCM)
+	RegisterCipher(ChaCha20Poly1305, 2, chacha20poly1305.New)
+}
+
+// 需要知道所属连接的编码解码器，比如按连接取密钥
+type channelBinder interface {
+	bindChannel(channel IChannel)
+}
+
+func bindCodec(codec ICodec, channel IChannel) {
+	walkCodec(codec, func(codec ICodec) bool {
+		if v, ok := codec.(channelBinder); ok {
+			v.bindChannel(channel)
+		}
+		return true
+	})
+}
+
+/**
+ * 加密编码解码器，包装另一个ICodec：
+ * Encode 时用连接的密钥加密消息体，消息体为 nonce+密文+认证标签，并把算法编号写入Isencrypted；
+ * Decode 时按Isencrypted 的编号解密并校验，校验失败返回ErrTampered，有密钥时收到明文返回ErrNotEncrypted。
+ * 密钥通过channel.SetAttribute(CipherKeyAttribute, key) 按连接设置，没有设置时使用默认密钥，
+ * 都没有时明文收发（用于交换密钥）。和压缩一起使用时加密要在压缩里面：
+ * NewCompressCodecFactory(NewCipherCodecFactory(...), ...)
+ * @author abram
+ */
+type CipherCodec struct {
+	ICodec
+	entry      *cipherEntry
+	defaultKey []byte
+	channel    IChannel
+
+	lock      sync.Mutex // Encode 和Decode 在不同的协程中调用
+	aeadCode  byte       // 缓存的AEAD 对应的算法编号和密钥，密钥不变时不用每次重新生成
+	aeadKey   []byte
+	aeadCache cipher.AEAD
+}
+
+/**
+ * 生成加密编码解码器
+ * @author abram
+ * @param codec 被包装的编码解码器
+ * @param name 加密算法的名字，AESGCM、ChaCha20Poly1305 或RegisterCipher 注册的名字
+ * @param defaultKey 连接没有设置密钥时使用的密钥，可以为nil
+ * @return ICodec
+ */
+func NewCipherCodec(codec ICodec, name string, defaultKey []byte) (ICodec, error) {
+	entry, ok := lookupCipher(name)
+	if !ok {
+		return nil, fmt.Errorf("socket: unknown cipher %q (forgotten RegisterCipher?)", name)
+	}
+	return &CipherCodec{ICodec: codec, entry: entry, defaultKey: defaultKey}, nil
+}
+
+func (codec *CipherCodec) bindChannel(channel IChannel) {
+	codec.channel = channel
+}
+
+// 被包装的编码解码器
+func (codec *CipherCodec) Unwrap() ICodec {
+	return codec.ICodec
+}
+
+// 连接通过属性设置的密钥，没有时返回nil
+func (codec *CipherCodec) channelKey() []byte {
+	if codec.channel != nil {
+		if v, ok := codec.channel.GetAttribute(CipherKeyAttribute); ok {
+			if key, ok := v.([]byte); ok && len(key) > 0 {
+				return key
+			}
+		}
+	}
+	return nil
+}
+
+// 当前连接的密钥
+func (codec *CipherCodec) key() []byte {
+	if key := codec.channelKey(); key != nil {
+		return key
+	}
+	return codec.defaultKey
+}
+
+// 算法和密钥对应的AEAD，和上次相同时使用缓存
+func (codec *CipherCodec) aead(entry *cipherEntry, key []byte) (cipher.AEAD, error) {
+	codec.lock.Lock()
+	defer codec.lock.Unlock()
+	if codec.aeadCache != nil && codec.aeadCode == entry.code && bytes.Equal(codec.aeadKey, key) {
+		return codec.aeadCache, nil
+	}
+	aead, err := entry.newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	codec.aeadCode = entry.code
+	codec.aeadKey = append(codec.aeadKey[:0], key...)
+	codec.aeadCache = aead
+	return aead, nil
+}
+
+// 连接的编码解码器中是否有按连接设置的密钥，这样的连接不能写入共享的数据帧
+func hasChannelKey(codec ICodec) bool {
+	found := false
+	walkCodec(codec, func(codec ICodec) bool {
+		if v, ok := codec.(*CipherCodec); ok && v.channelKey() != nil {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// 认证的附加数据，消息头被篡改也能检测出来
+func cipherAdditionalData(protoPack *ProtoPack) []byte {
+	return []byte{protoPack.Isencrypted, protoPack.Iscompressed, protoPack.PlatformId,
+		byte(uint16(protoPack.Id) >> 8), byte(protoPack.Id)}
+}
+
+func (codec *CipherCodec) Encode(protoPack ProtoPack) error {
+	key := codec.key()
+	if protoPack.Isencrypted != 0 || key == nil {
+		return codec.ICodec.Encode(protoPack)
+	}
+	aead, err := codec.aead(codec.entry, key)
+	if err != nil {
+		return err
+	}
+	protoPack.Isencrypted = codec.entry.code
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(protoPack.Body)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	protoPack.Body = aead.Seal(nonce, nonce, protoPack.Body, cipherAdditionalData(&protoPack))
+	return codec.ICodec.Encode(protoPack)
+}
+
+func (codec *CipherCodec) Decode() (*ProtoPack, error) {
+	protoPack, err := codec.ICodec.Decode()
+	if err != nil {
+		return protoPack, err
+	}
+	key := codec.key()
+	if protoPack.Isencrypted == 0 {
+		if key != nil {
+			return nil, ErrNotEncrypted
+		}
+		return protoPack, nil
+	}
+	entry, ok := lookupCipherCode(protoPack.Isencrypted)
+	if !ok {
+		return nil, ErrUnknownCipher
+	}
+	if key == nil {
+		return nil, ErrNoCipherKey
+	}
+	aead, err := codec.aead(entry, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(protoPack.Body) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrTampered
+	}
+	nonce, sealed := protoPack.Body[:aead.NonceSize()], protoPack.Body[aead.NonceSize():]
+	body, err := aead.Open(sealed[:0], nonce, sealed, cipherAdditionalData(protoPack))
+	if err != nil {
+		return nil, ErrTampered
+	}
+	protoPack.Body = body
+	protoPack.Isencrypted = 0
+	return protoPack, nil
+}
+
+// 加密编码解码工厂
+type CipherCodecFactory struct {
+	factory    ICodecFactory
+	name       string
+	defaultKey []byte
+}
+
+/**
+ * 生成加密编码解码工厂
+ * @author abram
+ * @param factory 被包装的工厂，为nil 时使用DefaultCodecFactory
+ * @param name 加密算法的名字
+ * @param defaultKey 连接没有设置密钥时使用的密钥，可以为nil
+ * @return ICodecFactory
+ */
+func NewCipherCodecFactory(factory ICodecFactory, name string, defaultKey []byte) (ICodecFactory, error) {
+	if factory == nil {
+		factory = NewDefaultCodecFactory()
+	}
+	if _, ok := lookupCipher(name); !ok {
+		return nil, fmt.Errorf("socket: unknown cipher %q (forgotten RegisterCipher?)", name)
+	}
+	return &CipherCodecFactory{factory: factory, name: name, defaultKey: defaultKey}, nil
+}
+
+func (factory *CipherCodecFactory) GetCodec(transport ITransport) ICodec {
+	codec, _ := NewCipherCodec(factory.factory.GetCodec(transport), factory.name, factory.defaultKey)
+	return codec
+}
@@ -0,0 +1,106 @@
+package socket
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCipherCodec(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	for _, name := range []string{AESGCM, ChaCha20Poly1305} {
+		factory, err := NewCipherCodecFactory(nil, name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		transport := &memoryTransport{}
+		codec := factory.GetCodec(transport)
+		channel := newDefaultChannel(transport, nil, codec)
+		channel.SetAttribute(CipherKeyAttribute, key)
+
+		if err := codec.Encode(ProtoPack{Id: 3, PlatformId: 1, Body: []byte("top secret")}); err != nil {
+			t.Fatal(name, err)
+		}
+		if bytes.Contains(transport.Bytes(), []byte("top secret")) {
+			t.Fatalf("%s: body sent in plain text", name)
+		}
+		protoPack, err := codec.Decode()
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if string(protoPack.Body) != "top secret" || protoPack.Isencrypted != 0 {
+			t.Fatalf("%s: round trip mismatch %+v", name, protoPack)
+		}
+
+		// 篡改消息头的平台号
+		if err := codec.Encode(ProtoPack{Id: 3, PlatformId: 1, Body: []byte("top secret")}); err != nil {
+			t.Fatal(name, err)
+		}
+		transport.Bytes()[2] = 9
+		if _, err := codec.Decode(); err != ErrTampered {
+			t.Fatalf("%s: expected ErrTampered, got %v", name, err)
+		}
+	}
+}
+
+// 统计生成AEAD 次数的加密算法，注册表是全局的，只注册一次
+var (
+	countingCipherOnce    sync.Once
+	countingCipherCreated int32
+)
+
+func TestCipherCodecKeyRequired(t *testing.T) {
+	countingCipherOnce.Do(func() {
+		RegisterCipher("test-counting", 200, func(key []byte) (cipher.AEAD, error) {
+			atomic.AddInt32(&countingCipherCreated, 1)
+			return newAESGCM(key)
+		})
+	})
+	atomic.StoreInt32(&countingCipherCreated, 0)
+	factory, err := NewCipherCodecFactory(nil, "test-counting", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &memoryTransport{}
+	codec := factory.GetCodec(transport)
+	client, server := net.Pipe()
+	defer server.Close()
+	socket, _ := NewSocketFromConnTimeout(client, 0)
+	framed := NewFramedTransport(socket)
+	channel := newDefaultChannel(socket, framed, codec)
+	defer channel.Close()
+
+	// 没有密钥时明文收发
+	NewDefaultCodec(transport).Encode(ProtoPack{Id: 1, Body: []byte("hello")})
+	if _, err := codec.Decode(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 设置了密钥后拒绝明文
+	channel.SetAttribute(CipherKeyAttribute, bytes.Repeat([]byte{1}, 32))
+	NewDefaultCodec(transport).Encode(ProtoPack{Id: 1, Body: []byte("hello")})
+	if _, err := codec.Decode(); err != ErrNotEncrypted {
+		t.Fatalf("expected ErrNotEncrypted, got %v", err)
+	}
+
+	// 密钥不变时AEAD 只生成一次
+	for i := 0; i < 3; i++ {
+		if err := codec.Encode(ProtoPack{Id: 1, Body: []byte("hello")}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := codec.Decode(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if created := atomic.LoadInt32(&countingCipherCreated); created != 1 {
+		t.Fatalf("AEAD created %d times", created)
+	}
+
+	// 共享的数据帧不能写给有自己密钥的连接
+	if err := channel.WriteFrame([]byte{0}); err != ErrFrameChannelKey {
+		t.Fatalf("expected ErrFrameChannelKey, got %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package socket
 
 import (
+	"crypto/tls"
 	"errors"
 	//"fmt"
 	//"log"
@@ -14,10 +15,31 @@ type Client struct {
 	addr              string
 	codecFactory      ICodecFactory
 	mutex             sync.RWMutex
-	socket            *Socket
+	socket            ITransport                                   //Socket 或WebSocket
 	connectedHandler  func(channel IChannel)                       //连接建立事件
 	disconnectHandler func(channel IChannel)                       //连接断开事件
 	messageHandler    func(channel IChannel, protoPack *ProtoPack) //消息处理逻辑
+	reconnect         *ReconnectPolicy                             //断线重连策略，nil 表示不重连
+	heartbeat         *HeartbeatConfig                             //心跳配置，nil 表示不启用
+	idleHandler       func(channel IChannel, state IdleState)      //空闲事件
+	tlsConfig         *tls.Config                                  //TLS 配置，nil 表示不加密
+	network           string                                       //网络类型
+	udpConfig         *UDPConfig                                   //UDP 传输配置
+	maxFrameSize      int                                          //最大数据帧长度
+	maxBodySize       int                                          //最大消息体长度
+	oversizeHandler   func(channel IChannel, err error)            //收到超长数据的事件
+	messages          *MessageRegistry                             //Send 使用的消息注册表
+	dispatcher        *dispatcher                                  //消息处理的调度
+	panicGuard        *panicGuard                                  //处理函数panic 时的处理
+	instrumentation   Instrumentation                              //监控，nil 表示不统计
+	writeQueue        *WriteQueueConfig                            //异步写队列配置
+	serializer        Serializer                                   //消息体的序列化方式
+	closed            bool                                         //是否调用过Close，关闭后不再重连
+	closing           chan struct{}                                //调用Close 时关闭，中断重连前的等待
+	channel           *DefaultChannel                              //当前的连接
+	queue             []ProtoPack                                  //重连期间缓存的待发送数据包
+	done              chan struct{}                                //后台协程结束时关闭
+	err               error                                        //后台协程结束的错误
 }
 
 // 生成一个客户端对象
@@ -52,71 +74,348 @@ func NewClient(config *Config) (*Client, error) {
 	client.connectedHandler = config.ConnectedHandler
 	client.messageHandler = config.MessageHandler
 	client.disconnectHandler = config.DisconnectHandler
+	client.reconnect = config.Reconnect
+	client.heartbeat = config.Heartbeat
+	client.idleHandler = config.IdleHandler
+	client.maxFrameSize = config.MaxFrameSize
+	client.maxBodySize = config.MaxBodySize
+	client.oversizeHandler = config.OversizeHandler
+	client.messages = config.Messages
+	client.serializer = config.Serializer
+	client.panicGuard = newPanicGuard(config.PanicHandler, config.PanicPolicy)
+	client.instrumentation = guardInstrumentation(config.Instrumentation, client.panicGuard)
+	client.dispatcher = newDispatcher(config.Dispatch, instrumentHandler(client.instrumentation, client.panicGuard.wrap(config.MessageHandler)))
+	client.writeQueue = config.WriteQueue
+	client.network = config.Network
+	client.udpConfig = config.UDP
+	if client.network != "" && client.network != tcp && client.network != udp {
+		return nil, errors.New("不支持的网络类型：" + client.network)
+	}
+	if client.network == udp && config.TLS != nil {
+		return nil, errors.New("UDP 不支持TLS。")
+	}
+	if config.TLS != nil {
+		tlsConfig, err := config.TLS.ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		client.tlsConfig = tlsConfig
+	}
 
 	client.stopped = true
 	return client, nil
 }
 
-//
+// 打开连接，连接成功并调用完ConnectedHandler 后返回，数据在后台协程中读取。
+// 配置了Config.Reconnect 时首次连接失败会按重连策略重试，连接断开后也会自动重连，
+// 直到Close 或超过最大重连次数。后台协程的结束可以通过Done/Wait 获知
 func (client *Client) Open() error {
+	client.mutex.Lock()
 	if client.stopped == false {
+		client.mutex.Unlock()
 		return errors.New("Client 已经打开。")
 	}
-
 	client.stopped = false
-	socket, err := NewSocketTimeout(client.addr, client.closingTimeout)
+	client.closed = false
+	client.closing = make(chan struct{})
+	client.err = nil
+	client.done = make(chan struct{})
+	client.mutex.Unlock()
+	client.dispatcher.start()
+
+	conn, err := client.redial(0)
 	if err != nil {
+		client.finish(err)
 		return err
 	}
+	go client.run(conn)
+	return nil
+}
+
+// 后台处理连接，断开后按重连策略重新连接
+func (client *Client) run(conn *clientConn) {
+	for {
+		err := client.serve(conn)
+		if client.isClosed() {
+			client.finish(nil)
+			return
+		}
+		if client.reconnect == nil {
+			client.finish(err)
+			return
+		}
+		if conn, err = client.redial(1); err != nil {
+			if client.isClosed() {
+				err = nil
+			}
+			client.finish(err)
+			return
+		}
+	}
+}
+
+// 等待重连间隔，期间调用了Close 时返回false
+func (client *Client) wait(delay time.Duration) bool {
+	client.mutex.RLock()
+	closing := client.closing
+	client.mutex.RUnlock()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-closing:
+		return false
+	}
+}
+
+// 从第attempt 次重连开始，连接直到成功、被关闭或超过最大重连次数
+func (client *Client) redial(attempt int) (*clientConn, error) {
+	for {
+		if attempt > 0 {
+			if !client.reconnect.allow(attempt) {
+				return nil, ErrReconnectExceeded
+			}
+			if !client.wait(client.reconnect.Backoff(attempt)) {
+				return nil, ErrChannelClosed
+			}
+		}
+		if client.isClosed() {
+			return nil, ErrChannelClosed
+		}
+		socket, err := client.dial()
+		if err == nil {
+			return client.connect(socket), nil
+		}
+		if client.reconnect == nil || err == ErrChannelClosed {
+			return nil, err
+		}
+		attempt++
+	}
+}
+
+// 后台协程结束，记录最终的错误并释放协程池
+func (client *Client) finish(err error) {
+	client.dispatcher.stop()
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.err = err
+	client.stopped = true
+	close(client.done)
+}
+
+// 后台协程结束时关闭的chan，Open 之前调用返回nil
+func (client *Client) Done() <-chan struct{} {
+	client.mutex.RLock()
+	defer client.mutex.RUnlock()
+	return client.done
+}
+
+// 等待后台协程结束，返回导致结束的错误，调用Close 结束时返回nil
+func (client *Client) Wait() error {
+	done := client.Done()
+	if done == nil {
+		return nil
+	}
+	<-done
+	return client.Err()
+}
+
+// 后台协程结束的错误，还在运行时返回nil
+func (client *Client) Err() error {
+	client.mutex.RLock()
+	defer client.mutex.RUnlock()
+	return client.err
+}
+
+// 建立socket 连接，连接期间调用了Close 时关闭新连接并返回ErrChannelClosed
+func (client *Client) dial() (ITransport, error) {
+	var socket ITransport
+	var err error
+	if client.network == udp {
+		socket, err = NewUDPSocket(client.addr, client.closingTimeout, client.udpConfig)
+	} else if isWebSocketURL(client.addr) {
+		socket, err = NewWebSocket(client.addr, client.closingTimeout, client.tlsConfig)
+	} else if client.tlsConfig != nil {
+		socket, err = NewSocketTLS(client.addr, client.closingTimeout, client.tlsConfig)
+	} else {
+		socket, err = NewSocketTimeout(client.addr, client.closingTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := socket.Open(); err != nil {
+		return nil, err
+	}
+	client.mutex.Lock()
+	if client.closed {
+		client.mutex.Unlock()
+		socket.Close()
+		return nil, ErrChannelClosed
+	}
 	client.socket = socket
-	if err := client.socket.Open(); err != nil {
-		return err
+	client.mutex.Unlock()
+	return socket, nil
+}
+
+// 是否已经调用过Close
+func (client *Client) isClosed() bool {
+	client.mutex.RLock()
+	defer client.mutex.RUnlock()
+	return client.closed
+}
+
+/**
+ * 发送数据包，连接断开且配置了重连时先放入待发送队列，重连成功后再发送
+ * @author abram
+ * @param data ProtoPack
+ */
+func (client *Client) Write(data interface{}) error {
+	client.mutex.Lock()
+	channel := client.channel
+	if channel == nil || !channel.IsOpen() {
+		defer client.mutex.Unlock()
+		protoPack, ok := data.(ProtoPack)
+		if !ok {
+			return ErrInvalidData
+		}
+		if client.closed || client.reconnect == nil || client.reconnect.QueueSize <= 0 {
+			return ErrChannelClosed
+		}
+		if len(client.queue) >= client.reconnect.QueueSize {
+			return ErrQueueFull
+		}
+		client.queue = append(client.queue, protoPack)
+		return nil
+	}
+	client.mutex.Unlock()
+	return channel.Write(data)
+}
+
+// 把重连期间缓存的数据包发出去，队列清空后才让Write 直接使用新连接，保证发送顺序。
+// 写数据时不持有锁，慢的socket 不会挡住其它协程的Write、Close 和状态查询
+func (client *Client) flushQueue(channel *DefaultChannel) {
+	for {
+		client.mutex.Lock()
+		queue := client.queue
+		client.queue = nil
+		if len(queue) == 0 {
+			client.channel = channel
+			client.mutex.Unlock()
+			return
+		}
+		client.mutex.Unlock()
+
+		for i, protoPack := range queue {
+			if err := channel.Write(protoPack); err != nil {
+				// 连接已经断开，没发出去的放回队列等下次重连
+				client.mutex.Lock()
+				client.queue = append(queue[i:len(queue):len(queue)], client.queue...)
+				client.mutex.Unlock()
+				return
+			}
+		}
 	}
-	client.connectionHandler()
-	return nil
 }
 
 //判断client是否已经打开
 func (client *Client) IsOpen() bool {
-	if client.socket == nil {
+	client.mutex.RLock()
+	socket := client.socket
+	client.mutex.RUnlock()
+	if socket == nil {
 		return false
 	}
-	return client.socket.IsOpen()
+	return socket.IsOpen()
 }
 
-//关闭连接
+//关闭连接，不再重连
 func (client *Client) Close() error {
-	if client.socket == nil {
+	client.mutex.Lock()
+	if !client.closed && client.closing != nil {
+		close(client.closing)
+	}
+	client.closed = true
+	socket := client.socket
+	client.mutex.Unlock()
+	if socket == nil {
 		return nil
 	}
 
-	return client.socket.Close()
+	return socket.Close()
 }
 
-//处理连接
-func (client *Client) connectionHandler() error {
-	transport := NewFramedTransport(client.socket)
+// 一次连接的状态
+type clientConn struct {
+	channel       *DefaultChannel
+	codec         ICodec
+	handlers      *channelDispatcher
+	stopHeartbeat func()
+}
+
+// 初始化连接，调用ConnectedHandler 并发出重连期间缓存的数据包
+func (client *Client) connect(socket ITransport) *clientConn {
+	transport := NewFramedTransport(socket)
+	transport.SetMaxFrameSize(client.maxFrameSize)
+	transport.SetInstrumentation(client.instrumentation)
 	codec := client.codecFactory.GetCodec(transport)
-	channel := NewDefaultChannel(client.socket, codec)
+	setMaxBodySize(codec, client.maxBodySize)
+	channel := newDefaultChannel(socket, transport, codec)
+	channel.messages = client.messages
+	channel.serializer = client.serializer
+	channel.startWriteQueue(client.writeQueue)
+	handlers := client.dispatcher.forChannel(channel)
+
+	if client.instrumentation != nil {
+		client.instrumentation.ConnectionOpened(channel)
+	}
+	client.panicGuard.call(channel, client.connectedHandler)
+	stopHeartbeat := startHeartbeat(channel, client.heartbeat, client.idleHandler, client.panicGuard)
+	client.flushQueue(channel)
+	return &clientConn{channel: channel, codec: codec, handlers: handlers, stopHeartbeat: stopHeartbeat}
+}
 
+//处理连接，读取数据直到连接断开
+func (client *Client) serve(conn *clientConn) error {
+	channel := conn.channel
 	defer func() {
+		conn.stopHeartbeat()
+		conn.handlers.close()
 		if client.disconnectHandler != nil {
-			go client.disconnectHandler(channel)
+			go client.panicGuard.call(channel, client.disconnectHandler)
 
 		}
+		if client.instrumentation != nil {
+			client.instrumentation.ConnectionClosed(channel)
+		}
+		client.mutex.Lock()
+		client.channel = nil
+		client.mutex.Unlock()
+		channel.failPending()
 		channel.Close()
 
 		time.Sleep(1000)
 	}()
-	client.connectedHandler(channel)
+
 	var protoPack *ProtoPack
 	var err error
 	for {
-		protoPack, err = codec.Decode()
+		protoPack, err = conn.codec.Decode()
 		if err != nil {
+			err = channel.closeReason(err)
+			reportOversize(client.panicGuard, channel, err, client.oversizeHandler)
+			if client.instrumentation != nil && !isClosedError(err) {
+				client.instrumentation.DecodeError(channel, err)
+			}
 			break
 		}
-		go client.messageHandler(channel, protoPack)
+		if channel.received(protoPack) || channel.complete(protoPack) {
+			continue
+		}
+		if client.instrumentation != nil {
+			client.instrumentation.MessageReceived(channel, protoPack)
+		}
+		conn.handlers.dispatch(protoPack)
 	}
 
 	return err
@@ -0,0 +1,194 @@
+package socket
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClientReconnect(t *testing.T) {
+	addr := freeAddr(t)
+	connected := make(chan IChannel, 4)
+	disconnected := make(chan struct{}, 4)
+	cliConfig := newTestConfig(addr)
+	cliConfig.ConnectedHandler = func(channel IChannel) { connected <- channel }
+	cliConfig.DisconnectHandler = func(channel IChannel) { disconnected <- struct{}{} }
+	cliConfig.Reconnect = NewReconnectPolicy()
+	cliConfig.Reconnect.InitialInterval = 10 * time.Millisecond
+	cliConfig.Reconnect.MaxInterval = 50 * time.Millisecond
+	client, err := NewClient(cliConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened := make(chan error, 1)
+	go func() { opened <- client.Open() }()
+
+	// 服务还没启动，数据包先进入待发送队列
+	if err := client.Write(ProtoPack{Id: 9}); err != nil {
+		t.Fatal(err)
+	}
+
+	srvConnected := make(chan IChannel, 4)
+	received := make(chan *ProtoPack, 4)
+	srvConfig := newTestConfig(addr)
+	srvConfig.ConnectedHandler = func(channel IChannel) { srvConnected <- channel }
+	srvConfig.MessageHandler = func(channel IChannel, protoPack *ProtoPack) { received <- protoPack }
+	startTestServer(t, srvConfig)
+
+	select {
+	case protoPack := <-received:
+		if protoPack.Id != 9 {
+			t.Fatalf("unexpected id %d", protoPack.Id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("queued packet was not sent after connecting")
+	}
+	<-connected
+
+	// 服务端断开后客户端应该重新连接
+	(<-srvConnected).Close()
+	<-disconnected
+	select {
+	case <-connected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("client did not reconnect")
+	}
+
+	if err := <-opened; err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	select {
+	case <-client.Done():
+		if err := client.Wait(); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("read loop did not stop after Close")
+	}
+}
+
+func TestClientOpenNonBlocking(t *testing.T) {
+	addr := freeAddr(t)
+	srvConnected := make(chan IChannel, 1)
+	srvConfig := newTestConfig(addr)
+	srvConfig.ConnectedHandler = func(channel IChannel) { srvConnected <- channel }
+	startTestServer(t, srvConfig)
+
+	cliConfig := newTestConfig(addr)
+	client, err := NewClient(cliConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Open(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-client.Done():
+		t.Fatal("Done closed while connected")
+	default:
+	}
+
+	(<-srvConnected).Close()
+	select {
+	case <-client.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Done not closed after disconnect")
+	}
+	if client.Wait() == nil {
+		t.Fatal("Wait should report the terminal read error")
+	}
+}
+
+func TestReconnectPolicyBackoff(t *testing.T) {
+	policy := &ReconnectPolicy{InitialInterval: time.Second, MaxInterval: 5 * time.Second, Multiplier: 2}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := policy.Backoff(i + 1); got != w {
+			t.Fatalf("Backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+}
+
+func TestReconnectPolicyBackoffOverflow(t *testing.T) {
+	policy := &ReconnectPolicy{InitialInterval: time.Second, Multiplier: 2, Jitter: 0.5}
+	for _, attempt := range []int{64, 1000, 100000} {
+		if got := policy.Backoff(attempt); got <= 0 || got > DefaultMaxReconnectInterval {
+			t.Fatalf("Backoff(%d) = %v, want (0, %v]", attempt, got, DefaultMaxReconnectInterval)
+		}
+	}
+}
+
+func TestClientCloseDuringBackoff(t *testing.T) {
+	cliConfig := newTestConfig(freeAddr(t))
+	cliConfig.Reconnect = NewReconnectPolicy()
+	cliConfig.Reconnect.InitialInterval = time.Minute
+	client, err := NewClient(cliConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened := make(chan error, 1)
+	go func() { opened <- client.Open() }()
+
+	// 首次连接失败后进入一分钟的等待，Close 应该立即打断
+	time.Sleep(50 * time.Millisecond)
+	client.Close()
+	select {
+	case err := <-opened:
+		if err != ErrChannelClosed {
+			t.Fatalf("expected ErrChannelClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close did not interrupt the reconnect wait")
+	}
+}
+
+func TestClientFlushQueueWithoutLock(t *testing.T) {
+	cliConfig := newTestConfig(freeAddr(t))
+	cliConfig.Reconnect = NewReconnectPolicy()
+	client, err := NewClient(cliConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.queue = []ProtoPack{{Id: 1}, {Id: 2}}
+
+	local, peer := net.Pipe()
+	defer peer.Close()
+	socket, _ := NewSocketFromConnTimeout(local, 0)
+	transport := NewFramedTransport(socket)
+	channel := newDefaultChannel(socket, transport, NewDefaultCodec(transport))
+	defer channel.Close()
+	flushed := make(chan struct{})
+	go func() {
+		client.flushQueue(channel)
+		close(flushed)
+	}()
+
+	// 对端没有读取，flushQueue 阻塞在写上，这时Write 不能被锁挡住
+	written := make(chan error, 1)
+	go func() { written <- client.Write(ProtoPack{Id: 3}) }()
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Write blocked while the queue was flushing")
+	}
+
+	peerSocket, _ := NewSocketFromConnTimeout(peer, 0)
+	codec := NewDefaultCodec(NewFramedTransport(peerSocket))
+	for want := int16(1); want <= 3; want++ {
+		protoPack, err := codec.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if protoPack.Id != want {
+			t.Fatalf("got id %d, want %d", protoPack.Id, want)
+		}
+	}
+	<-flushed
+	if client.channel != channel {
+		t.Fatal("channel not published after the queue was flushed")
+	}
+}
@@ -2,6 +2,7 @@ package socket
 
 import (
 	"encoding/binary"
+	"errors"
 	"io"
 	//"log"
 	"math"
@@ -55,18 +56,86 @@ type ICodec interface {
 	FlushAndClose() error
 }
 
+// 包装其它编码解码器的编码解码器，比如CompressCodec
+type codecWrapper interface {
+	Unwrap() ICodec
+}
+
+// 从外到里遍历编码解码器链，fn 返回false 时停止
+func walkCodec(codec ICodec, fn func(codec ICodec) bool) {
+	for codec != nil && fn(codec) {
+		v, ok := codec.(codecWrapper)
+		if !ok {
+			return
+		}
+		codec = v.Unwrap()
+	}
+}
+
 /**
  * 默认的编码解码器
  * @author abram
  */
 type DefaultCodec struct {
-	lock      sync.RWMutex
-	buffer    [8]byte
-	transport ITransport //FramedTransport
+	lock        sync.Mutex
+	buffer      [8]byte    // 读用的缓存
+	writeBuf    [8]byte    // 写用的缓存，和读分开，读写可以在不同的协程中同时进行
+	transport   ITransport //FramedTransport
+	sequenced   bool       //是否在消息id后写入请求序号
+	maxBodySize int        //消息体和字符串的最大长度
 }
 
 func NewDefaultCodec(transport ITransport) ICodec {
-	return &DefaultCodec{transport: transport}
+	return &DefaultCodec{transport: transport, maxBodySize: DefaultMaxFrameSize}
+}
+
+// 生成一个带请求序号的编码解码器，消息头在Id之后多4个字节的Seq
+func NewSequencedCodec(transport ITransport) ICodec {
+	return &DefaultCodec{transport: transport, sequenced: true, maxBodySize: DefaultMaxFrameSize}
+}
+
+// 按消息检查数据帧边界的传输层，比如FramedTransport
+type messageReader interface {
+	beginMessage()
+}
+
+// 可以限制消息体长度的编码解码器
+type bodyLimiter interface {
+	SetMaxBodySize(size int)
+}
+
+// 设置编码解码器链上所有层的最大消息体长度
+func setMaxBodySize(codec ICodec, size int) {
+	walkCodec(codec, func(codec ICodec) bool {
+		if v, ok := codec.(bodyLimiter); ok {
+			v.SetMaxBodySize(size)
+		}
+		return true
+	})
+}
+
+// 设置消息体和字符串的最大长度，小于等于0 时使用DefaultMaxFrameSize
+func (codec *DefaultCodec) SetMaxBodySize(size int) {
+	if size <= 0 {
+		size = DefaultMaxFrameSize
+	}
+	codec.maxBodySize = size
+}
+
+// 检查读到的长度
+func (codec *DefaultCodec) checkSize(size int32) error {
+	if size < 0 {
+		return &SizeError{Err: ErrNegativeLength, Size: int64(size), Limit: codec.maxBodySize}
+	}
+	if int(size) > codec.maxBodySize {
+		return &SizeError{Err: ErrBodyTooLarge, Size: int64(size), Limit: codec.maxBodySize}
+	}
+	return nil
+}
+
+// 是否带请求序号
+func (codec *DefaultCodec) Sequenced() bool {
+	return codec.sequenced
 }
 
 /**
@@ -77,40 +146,60 @@ func NewDefaultCodec(transport ITransport) ICodec {
  */
 func (codec *DefaultCodec) Decode() (protoPack *ProtoPack, err error) {
 	protoPack = NewProtoPack()
+	if v, ok := codec.transport.(messageReader); ok {
+		v.beginMessage()
+	}
 	v, err := codec.ReadByte()
 	if err != nil {
-
+		return nil, classifyError("read", err)
 	}
 	protoPack.Isencrypted = v
 
 	v, err = codec.ReadByte()
 	if err != nil {
-		return nil, err
+		return nil, headerError(err)
 	}
 	protoPack.Iscompressed = v
 
 	v, err = codec.ReadByte()
 	if err != nil {
-		return nil, err
+		return nil, headerError(err)
 	}
 	protoPack.PlatformId = v
 
 	var v16 int16
 	v16, err = codec.ReadInt16()
 	if err != nil {
-		return nil, err
+		return nil, headerError(err)
 	}
 	protoPack.Id = v16
 
+	if codec.sequenced {
+		var v32 int32
+		v32, err = codec.ReadInt32()
+		if err != nil {
+			return nil, headerError(err)
+		}
+		protoPack.Seq = uint32(v32)
+	}
+
 	var bv []byte
 	bv, err = codec.ReadBinary()
 	if err != nil {
-		return nil, err
+		return nil, classifyError("read", err)
 	}
 	protoPack.Body = bv
 	return protoPack, nil
 }
 
+// 读了一部分消息头之后的错误，数据在消息头中间结束时是ErrMalformedHeader
+func headerError(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return &OpError{Op: "read", Kind: ErrMalformedHeader, Err: err}
+	}
+	return classifyError("read", err)
+}
+
 /**
  * 数据编码方法
  * @author abram
@@ -118,9 +207,13 @@ func (codec *DefaultCodec) Decode() (protoPack *ProtoPack, err error) {
  * @return []byte
  */
 func (codec *DefaultCodec) Encode(protoPack ProtoPack) error {
-	codec.lock.RLock()
-	defer codec.lock.RUnlock()
+	// 编码要独占写缓存，否则并发写的数据帧会交错在一起
+	codec.lock.Lock()
+	defer codec.lock.Unlock()
+	return classifyError("write", codec.encode(protoPack))
+}
 
+func (codec *DefaultCodec) encode(protoPack ProtoPack) error {
 	if err := codec.WriteByte(protoPack.Isencrypted); err != nil {
 		return err
 	}
@@ -133,6 +226,11 @@ func (codec *DefaultCodec) Encode(protoPack ProtoPack) error {
 	if err := codec.WriteInt16(protoPack.Id); err != nil {
 		return err
 	}
+	if codec.sequenced {
+		if err := codec.WriteInt32(int32(protoPack.Seq)); err != nil {
+			return err
+		}
+	}
 	if err := codec.WriteBinary(protoPack.Body); err != nil {
 		return err
 	}
@@ -192,6 +290,9 @@ func (codec *DefaultCodec) ReadString() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if err := codec.checkSize(size); err != nil {
+		return "", err
+	}
 
 	return codec.ReadStringBody(int(size))
 }
@@ -210,6 +311,9 @@ func (codec *DefaultCodec) ReadBinary() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := codec.checkSize(size); err != nil {
+		return nil, err
+	}
 	isize := int(size)
 	buf := make([]byte, isize)
 	_, e := io.ReadFull(codec.transport, buf)
@@ -230,21 +334,21 @@ func (codec *DefaultCodec) WriteBool(value bool) error {
 }
 
 func (codec *DefaultCodec) WriteInt16(value int16) error {
-	v := codec.buffer[0:2]
+	v := codec.writeBuf[0:2]
 	binary.BigEndian.PutUint16(v, uint16(value))
 	_, err := codec.transport.Write(v)
 	return err
 }
 
 func (codec *DefaultCodec) WriteInt32(value int32) error {
-	v := codec.buffer[0:4]
+	v := codec.writeBuf[0:4]
 	binary.BigEndian.PutUint32(v, uint32(value))
 	_, err := codec.transport.Write(v)
 	return err
 }
 
 func (codec *DefaultCodec) WriteInt64(value int64) error {
-	v := codec.buffer[0:8]
+	v := codec.writeBuf[0:8]
 	binary.BigEndian.PutUint64(v, uint64(value))
 	_, err := codec.transport.Write(v)
 	return err
@@ -276,5 +380,9 @@ func (codec *DefaultCodec) Close() error {
 }
 
 func (codec *DefaultCodec) FlushAndClose() error {
-	return codec.FlushAndClose()
+	if err := codec.transport.Flush(); err != nil {
+		codec.transport.Close()
+		return err
+	}
+	return codec.transport.Close()
 }
@@ -8,6 +8,7 @@ type ICodecFactory interface {
 }
 
 type DefaultCodecFactory struct {
+	sequenced bool
 }
 
 //获取默认的解码工厂类
@@ -15,7 +16,15 @@ func NewDefaultCodecFactory() ICodecFactory {
 	return &DefaultCodecFactory{}
 }
 
+// 获取带请求序号的解码工厂类，IChannel.Call 需要通信双方都使用此工厂
+func NewSequencedCodecFactory() ICodecFactory {
+	return &DefaultCodecFactory{sequenced: true}
+}
+
 //获取默认的解码器
 func (factory *DefaultCodecFactory) GetCodec(transport ITransport) ICodec {
+	if factory.sequenced {
+		return NewSequencedCodec(transport)
+	}
 	return NewDefaultCodec(transport)
 }
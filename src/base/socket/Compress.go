@@ -0,0 +1,263 @@
+package socket
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"sync"
+)
+
+var ErrUnknownCompressor = errors.New("未知的压缩算法。")
+
+const (
+	Gzip   = "gzip"
+	Zlib   = "zlib"
+	Flate  = "flate"
+	Snappy = "snappy"
+)
+
+/**
+ * 压缩算法接口
+ * @author abram
+ */
+type Compressor interface {
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// 可以限制解压后长度的压缩算法，超过limit 时返回ErrBodyTooLarge，不会先把数据全部解压出来
+type limitedDecompressor interface {
+	DecompressLimit(data []byte, limit int) ([]byte, error)
+}
+
+// 注册的压缩算法
+type compressorEntry struct {
+	name       string
+	code       byte
+	compressor Compressor
+}
+
+var (
+	compressorLock  sync.RWMutex
+	compressors     = make(map[string]*compressorEntry)
+	compressorCodes = make(map[byte]*compressorEntry)
+)
+
+/**
+ * 注册压缩算法
+ * @author abram
+ * @param name 压缩算法的名字
+ * @param code 写在ProtoPack.Iscompressed 中的编号，不能为0，通信双方要一致
+ * @param compressor 压缩算法
+ */
+func RegisterCompressor(name string, code byte, compressor Compressor) {
+	if compressor == nil {
+		panic("socket:RegisterCompressor compressor is nil")
+	}
+	if code == 0 {
+		panic("socket:RegisterCompressor code 0 is reserved for uncompressed bodies")
+	}
+	compressorLock.Lock()
+	defer compressorLock.Unlock()
+	if _, dup := compressors[name]; dup {
+		panic("socket:RegisterCompressor called twice for compressor " + name)
+	}
+	if _, dup := compressorCodes[code]; dup {
+		panic("socket:RegisterCompressor called twice for code " + strconv.Itoa(int(code)))
+	}
+	entry := &compressorEntry{name: name, code: code, compressor: compressor}
+	compressors[name] = entry
+	compressorCodes[code] = entry
+}
+
+func lookupCompressor(name string) (*compressorEntry, bool) {
+	compressorLock.RLock()
+	defer compressorLock.RUnlock()
+	entry, ok := compressors[name]
+	return entry, ok
+}
+
+func lookupCompressorCode(code byte) (*compressorEntry, bool) {
+	compressorLock.RLock()
+	defer compressorLock.RUnlock()
+	entry, ok := compressorCodes[code]
+	return entry, ok
+}
+
+// 基于compress 标准库的压缩算法
+type streamCompressor struct {
+	newWriter func(w io.Writer) (io.WriteCloser, error)
+	newReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+func (compressor *streamCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := compressor.newWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (compressor *streamCompressor) Decompress(data []byte) ([]byte, error) {
+	return compressor.DecompressLimit(data, math.MaxInt32)
+}
+
+// 最多解压limit+1 个字节，防止很小的压缩数据解压出几个G
+func (compressor *streamCompressor) DecompressLimit(data []byte, limit int) ([]byte, error) {
+	r, err := compressor.newReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > limit {
+		return nil, &SizeError{Err: ErrBodyTooLarge, Size: int64(len(body)), Limit: limit}
+	}
+	return body, nil
+}
+
+func init() {
+	RegisterCompressor(Gzip, 1, &streamCompressor{
+		newWriter: func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+		newReader: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	})
+	RegisterCompressor(Zlib, 2, &streamCompressor{
+		newWriter: func(w io.Writer) (io.WriteCloser, error) { return zlib.NewWriter(w), nil },
+		newReader: func(r io.Reader) (io.ReadCloser, error) { return zlib.NewReader(r) },
+	})
+	RegisterCompressor(Flate, 3, &streamCompressor{
+		newWriter: func(w io.Writer) (io.WriteCloser, error) { return flate.NewWriter(w, flate.DefaultCompression) },
+		newReader: func(r io.Reader) (io.ReadCloser, error) { return flate.NewReader(r), nil },
+	})
+	RegisterCompressor(Snappy, 4, &SnappyCompressor{})
+}
+
+/**
+ * 压缩编码解码器，包装另一个ICodec：
+ * Encode 时消息体不小于threshold 就压缩，并把算法编号写入Iscompressed；
+ * Decode 时按Iscompressed 的编号自动解压，解压后Iscompressed 置为0
+ * @author abram
+ */
+type CompressCodec struct {
+	ICodec
+	entry       *compressorEntry
+	threshold   int
+	maxBodySize int
+}
+
+/**
+ * 生成压缩编码解码器
+ * @author abram
+ * @param codec 被包装的编码解码器
+ * @param name 压缩算法的名字
+ * @param threshold 消息体达到多少字节才压缩
+ * @return ICodec
+ */
+func NewCompressCodec(codec ICodec, name string, threshold int) (ICodec, error) {
+	entry, ok := lookupCompressor(name)
+	if !ok {
+		return nil, fmt.Errorf("socket: unknown compressor %q (forgotten RegisterCompressor?)", name)
+	}
+	return &CompressCodec{ICodec: codec, entry: entry, threshold: threshold, maxBodySize: DefaultMaxFrameSize}, nil
+}
+
+// 设置解压后消息体的最大长度，小于等于0 时使用DefaultMaxFrameSize
+func (codec *CompressCodec) SetMaxBodySize(size int) {
+	if size <= 0 {
+		size = DefaultMaxFrameSize
+	}
+	codec.maxBodySize = size
+}
+
+func (codec *CompressCodec) Encode(protoPack ProtoPack) error {
+	if protoPack.Iscompressed == 0 && len(protoPack.Body) > 0 && len(protoPack.Body) >= codec.threshold {
+		body, err := codec.entry.compressor.Compress(protoPack.Body)
+		if err != nil {
+			return err
+		}
+		// 压缩后反而变大就不压缩了
+		if len(body) < len(protoPack.Body) {
+			protoPack.Body = body
+			protoPack.Iscompressed = codec.entry.code
+		}
+	}
+	return codec.ICodec.Encode(protoPack)
+}
+
+func (codec *CompressCodec) Decode() (*ProtoPack, error) {
+	protoPack, err := codec.ICodec.Decode()
+	if err != nil || protoPack.Iscompressed == 0 {
+		return protoPack, err
+	}
+	entry, ok := lookupCompressorCode(protoPack.Iscompressed)
+	if !ok {
+		return nil, ErrUnknownCompressor
+	}
+	var body []byte
+	if v, ok := entry.compressor.(limitedDecompressor); ok {
+		body, err = v.DecompressLimit(protoPack.Body, codec.maxBodySize)
+	} else {
+		body, err = entry.compressor.Decompress(protoPack.Body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > codec.maxBodySize {
+		return nil, &SizeError{Err: ErrBodyTooLarge, Size: int64(len(body)), Limit: codec.maxBodySize}
+	}
+	protoPack.Body = body
+	protoPack.Iscompressed = 0
+	return protoPack, nil
+}
+
+// 被包装的编码解码器
+func (codec *CompressCodec) Unwrap() ICodec {
+	return codec.ICodec
+}
+
+// 压缩编码解码工厂
+type CompressCodecFactory struct {
+	factory   ICodecFactory
+	name      string
+	threshold int
+}
+
+/**
+ * 生成压缩编码解码工厂
+ * @author abram
+ * @param factory 被包装的工厂，为nil 时使用DefaultCodecFactory
+ * @param name 压缩算法的名字，Gzip、Zlib、Flate、Snappy 或RegisterCompressor 注册的名字
+ * @param threshold 消息体达到多少字节才压缩
+ * @return ICodecFactory
+ */
+func NewCompressCodecFactory(factory ICodecFactory, name string, threshold int) (ICodecFactory, error) {
+	if factory == nil {
+		factory = NewDefaultCodecFactory()
+	}
+	if _, ok := lookupCompressor(name); !ok {
+		return nil, fmt.Errorf("socket: unknown compressor %q (forgotten RegisterCompressor?)", name)
+	}
+	return &CompressCodecFactory{factory: factory, name: name, threshold: threshold}, nil
+}
+
+func (factory *CompressCodecFactory) GetCodec(transport ITransport) ICodec {
+	codec, _ := NewCompressCodec(factory.factory.GetCodec(transport), factory.name, factory.threshold)
+	return codec
+}
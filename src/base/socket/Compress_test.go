@@ -0,0 +1,104 @@
+package socket
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"runtime"
+	"testing"
+)
+
+func TestCompressCodec(t *testing.T) {
+	body := bytes.Repeat([]byte("compress me please "), 100)
+	for _, name := range []string{Gzip, Zlib, Flate, Snappy} {
+		factory, err := NewCompressCodecFactory(nil, name, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		transport := &memoryTransport{}
+		codec := factory.GetCodec(transport)
+		if err := codec.Encode(ProtoPack{Id: 1, Body: body}); err != nil {
+			t.Fatal(name, err)
+		}
+		if transport.Len() >= len(body) {
+			t.Fatalf("%s: body was not compressed (%d bytes)", name, transport.Len())
+		}
+		if err := codec.Encode(ProtoPack{Id: 2, Body: []byte("tiny")}); err != nil {
+			t.Fatal(name, err)
+		}
+
+		protoPack, err := codec.Decode()
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if !bytes.Equal(protoPack.Body, body) || protoPack.Iscompressed != 0 {
+			t.Fatalf("%s: round trip mismatch", name)
+		}
+		protoPack, err = codec.Decode()
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if string(protoPack.Body) != "tiny" {
+			t.Fatalf("%s: small body mismatch %q", name, protoPack.Body)
+		}
+	}
+}
+
+func TestCompressBomb(t *testing.T) {
+	// 很小的压缩数据解压出8MB，超过1MB 的限制时不能先全部解压
+	body := make([]byte, 8<<20)
+	for _, name := range []string{Gzip, Zlib, Flate, Snappy} {
+		factory, err := NewCompressCodecFactory(nil, name, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		transport := &memoryTransport{}
+		codec := factory.GetCodec(transport)
+		if err := codec.Encode(ProtoPack{Id: 1, Body: body}); err != nil {
+			t.Fatal(name, err)
+		}
+		setMaxBodySize(codec, 1<<20)
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err = codec.Decode()
+		runtime.ReadMemStats(&after)
+		if !errors.Is(err, ErrBodyTooLarge) {
+			t.Fatalf("%s: expected ErrBodyTooLarge, got %v", name, err)
+		}
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 6<<20 {
+			t.Fatalf("%s: allocated %d bytes before rejecting the body", name, allocated)
+		}
+	}
+}
+
+func TestSnappyRoundTrip(t *testing.T) {
+	random := make([]byte, 5000)
+	rand.New(rand.NewSource(1)).Read(random)
+	inputs := [][]byte{
+		{},
+		[]byte("a"),
+		bytes.Repeat([]byte{'x'}, 100000),
+		bytes.Repeat([]byte("abcdefgh12345678"), 5000),
+		random,
+		append(append([]byte{}, random...), random...),
+	}
+	compressor := &SnappyCompressor{}
+	for i, input := range inputs {
+		compressed, err := compressor.Compress(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		output, err := compressor.Decompress(compressed)
+		if err != nil {
+			t.Fatalf("input %d: %v", i, err)
+		}
+		if !bytes.Equal(input, output) {
+			t.Fatalf("input %d: round trip mismatch", i)
+		}
+	}
+
+	if _, err := compressor.Decompress([]byte{10, 0x01, 0xff}); err != ErrSnappyCorrupt {
+		t.Fatalf("expected ErrSnappyCorrupt, got %v", err)
+	}
+}
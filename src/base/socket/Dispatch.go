@@ -0,0 +1,189 @@
+package socket
+
+import (
+	"runtime"
+	"sync"
+)
+
+// 消息处理函数的调度方式
+type DispatchMode int
+
+const (
+	DispatchUnbounded DispatchMode = iota // 每个消息一个协程，不保证顺序
+	DispatchOrdered                       // 每个连接一个协程，按收到的顺序依次处理
+	DispatchPool                          // 所有连接共用固定数量的协程
+)
+
+/**
+ * 消息调度配置，队列满了之后会暂停读取这个连接的数据，直到队列有空位
+ * @author abram
+ */
+type DispatchConfig struct {
+	Mode      DispatchMode
+	QueueSize int // DispatchOrdered 时每个连接的队列长度，DispatchPool 时共用的队列长度
+	Workers   int // DispatchPool 时的协程数量
+}
+
+/**
+ * 生成默认的调度配置，默认为DispatchUnbounded
+ * @author abram
+ * @return DispatchConfig
+ */
+func NewDispatchConfig() *DispatchConfig {
+	return &DispatchConfig{
+		Mode:      DispatchUnbounded,
+		QueueSize: 1024,
+		Workers:   runtime.NumCPU() * 4,
+	}
+}
+
+// 交给协程池处理的消息
+type dispatchTask struct {
+	channel   IChannel
+	protoPack *ProtoPack
+	done      *sync.WaitGroup
+}
+
+// 一个Server 或Client 的消息调度器
+type dispatcher struct {
+	mode      DispatchMode
+	queueSize int
+	workers   int
+	handler   func(channel IChannel, protoPack *ProtoPack)
+
+	lock     sync.Mutex
+	pool     chan dispatchTask // 协程池的队列，没有连接使用时为nil
+	users    int               // 正在使用协程池的连接数
+	stopping bool              // Server 或Client 已经停止，最后一个连接结束时关闭协程池
+}
+
+func newDispatcher(config *DispatchConfig, handler func(channel IChannel, protoPack *ProtoPack)) *dispatcher {
+	defaults := NewDispatchConfig()
+	if config == nil {
+		config = defaults
+	}
+	d := &dispatcher{mode: config.Mode, queueSize: config.QueueSize, workers: config.Workers, handler: handler}
+	if d.queueSize <= 0 {
+		d.queueSize = defaults.QueueSize
+	}
+	if d.workers <= 0 {
+		d.workers = defaults.Workers
+	}
+	return d
+}
+
+// 一个连接开始使用协程池，第一次使用或上一次关闭后重新启动
+func (d *dispatcher) acquirePool() chan dispatchTask {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.pool == nil {
+		pool := make(chan dispatchTask, d.queueSize)
+		for i := 0; i < d.workers; i++ {
+			go func() {
+				for task := range pool {
+					d.handler(task.channel, task.protoPack)
+					task.done.Done()
+				}
+			}()
+		}
+		d.pool = pool
+	}
+	d.users++
+	return d.pool
+}
+
+// 一个连接不再往协程池放消息，停止后的最后一个连接关闭协程池
+func (d *dispatcher) releasePool() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.users--
+	if d.users == 0 && d.stopping {
+		d.closePool()
+	}
+}
+
+// 关闭协程池的队列，协程处理完队列中剩下的消息后退出，调用前要加锁
+func (d *dispatcher) closePool() {
+	if d.pool != nil {
+		close(d.pool)
+		d.pool = nil
+	}
+}
+
+// Server 或Client 重新启动
+func (d *dispatcher) start() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.stopping = false
+}
+
+// Server 或Client 停止，没有连接在使用时马上关闭协程池，否则等最后一个连接结束
+func (d *dispatcher) stop() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.stopping = true
+	if d.users == 0 {
+		d.closePool()
+	}
+}
+
+// 生成一个连接的调度器
+func (d *dispatcher) forChannel(channel IChannel) *channelDispatcher {
+	cd := &channelDispatcher{dispatcher: d, channel: channel}
+	switch d.mode {
+	case DispatchOrdered:
+		cd.queue = make(chan *ProtoPack, d.queueSize)
+		cd.handlers.Add(1)
+		go func() {
+			defer cd.handlers.Done()
+			for protoPack := range cd.queue {
+				d.handler(channel, protoPack)
+			}
+		}()
+	case DispatchPool:
+		cd.pool = d.acquirePool()
+	}
+	return cd
+}
+
+// 一个连接的消息调度
+type channelDispatcher struct {
+	dispatcher *dispatcher
+	channel    IChannel
+	handlers   sync.WaitGroup // 还没处理完的消息
+	queue      chan *ProtoPack
+	pool       chan dispatchTask // DispatchPool 时使用的协程池队列
+}
+
+// 处理一个消息，队列满了会阻塞，调用方（读协程）因此暂停读取
+func (cd *channelDispatcher) dispatch(protoPack *ProtoPack) {
+	d := cd.dispatcher
+	switch d.mode {
+	case DispatchOrdered:
+		cd.queue <- protoPack
+	case DispatchPool:
+		cd.handlers.Add(1)
+		cd.pool <- dispatchTask{channel: cd.channel, protoPack: protoPack, done: &cd.handlers}
+	default:
+		cd.handlers.Add(1)
+		go func() {
+			defer cd.handlers.Done()
+			d.handler(cd.channel, protoPack)
+		}()
+	}
+}
+
+// 不会再有新的消息，DispatchOrdered 的协程处理完队列后退出，DispatchPool 时释放协程池
+func (cd *channelDispatcher) close() {
+	if cd.queue != nil {
+		close(cd.queue)
+	}
+	if cd.pool != nil {
+		cd.dispatcher.releasePool()
+	}
+}
+
+// 等已经收到的消息处理完
+func (cd *channelDispatcher) wait() {
+	cd.handlers.Wait()
+}
@@ -0,0 +1,111 @@
+package socket
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDispatchOrdered(t *testing.T) {
+	var lock sync.Mutex
+	var got []int16
+	running := 0
+	d := newDispatcher(&DispatchConfig{Mode: DispatchOrdered, QueueSize: 4}, func(channel IChannel, protoPack *ProtoPack) {
+		lock.Lock()
+		running++
+		if running > 1 {
+			t.Error("handlers of one channel run concurrently")
+		}
+		lock.Unlock()
+		time.Sleep(time.Millisecond)
+		lock.Lock()
+		running--
+		got = append(got, protoPack.Id)
+		lock.Unlock()
+	})
+	handlers := d.forChannel(nil)
+	for i := 0; i < 20; i++ {
+		handlers.dispatch(&ProtoPack{Id: int16(i)})
+	}
+	handlers.close()
+	handlers.wait()
+	for i, id := range got {
+		if id != int16(i) {
+			t.Fatalf("out of order: %v", got)
+		}
+	}
+	if len(got) != 20 {
+		t.Fatalf("got %d messages", len(got))
+	}
+}
+
+func TestDispatchPoolBackpressure(t *testing.T) {
+	gate := make(chan struct{})
+	d := newDispatcher(&DispatchConfig{Mode: DispatchPool, QueueSize: 1, Workers: 2}, func(channel IChannel, protoPack *ProtoPack) {
+		<-gate
+	})
+	defer d.stop()
+	handlers := d.forChannel(nil)
+	defer handlers.close()
+
+	dispatched := make(chan int, 4)
+	go func() {
+		for i := 0; i < 4; i++ {
+			handlers.dispatch(&ProtoPack{Id: int16(i)})
+			dispatched <- i
+		}
+	}()
+	// 两个协程各处理一个，队列里放一个，第4 个要等
+	for i := 0; i < 3; i++ {
+		select {
+		case <-dispatched:
+		case <-time.After(time.Second):
+			t.Fatal("dispatch blocked too early")
+		}
+	}
+	select {
+	case <-dispatched:
+		t.Fatal("dispatch should block when the pool queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(gate)
+	<-dispatched
+	handlers.wait()
+}
+
+func TestDispatchPoolRelease(t *testing.T) {
+	handled := make(chan int16, 1)
+	d := newDispatcher(&DispatchConfig{Mode: DispatchPool, QueueSize: 1, Workers: 2}, func(channel IChannel, protoPack *ProtoPack) {
+		handled <- protoPack.Id
+	})
+	handlers := d.forChannel(nil)
+	pool := handlers.pool
+
+	// 停止后还有连接在用，协程池要等连接结束
+	d.stop()
+	handlers.dispatch(&ProtoPack{Id: 1})
+	if <-handled != 1 || d.pool == nil {
+		t.Fatal("pool closed while a channel still uses it")
+	}
+	handlers.close()
+	handlers.wait()
+	if d.pool != nil {
+		t.Fatal("pool not released after the last channel closed")
+	}
+	if _, ok := <-pool; ok {
+		t.Fatal("pool queue not closed")
+	}
+
+	// 重新启动后生成新的协程池
+	d.start()
+	handlers = d.forChannel(nil)
+	handlers.dispatch(&ProtoPack{Id: 2})
+	if <-handled != 2 {
+		t.Fatal("pool not restarted")
+	}
+	handlers.close()
+	d.stop()
+	if d.pool != nil {
+		t.Fatal("pool not released on stop")
+	}
+}
@@ -0,0 +1,86 @@
+package socket
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+)
+
+// 连接出错的种类，可以用errors.Is 判断，Decode、Encode、IChannel.Write 和Config.ErrorHandler 收到的错误都可以判断
+var (
+	ErrConnectionClosed = ErrChannelClosed       // 连接正常关闭，包括对方关闭和本地关闭
+	ErrPeerReset        = errors.New("连接被对方重置。") // 对方异常断开，比如进程崩溃
+	ErrTimeout          = errors.New("读写超时。")    // 读写超时，比如设置了超时的Socket 长时间没有数据，或心跳连续没有响应
+	ErrMalformedHeader  = errors.New("消息头格式错误。") // 消息头不完整、长度为负数或消息在数据帧中间结束
+	ErrInvalidData      = errors.New("错误的数据。")   // Write 的参数不是ProtoPack
+	errReadInterrupted  = errors.New("Socket 读已中断。")
+	errShortFrame       = errors.New("数据帧在消息中间结束。")
+	errHeartbeatTimeout = errors.New("心跳没有响应。")
+)
+
+/**
+ * 带种类的连接错误，Kind 是上面的种类或ErrFrameTooLarge、ErrBodyTooLarge，
+ * Err 是底层的原始错误，errors.Is 和errors.As 对两者都有效
+ * @author abram
+ */
+type OpError struct {
+	Op   string // read 或write
+	Kind error
+	Err  error
+}
+
+func (e *OpError) Error() string {
+	if e.Err == nil || e.Err == e.Kind {
+		return e.Op + ": " + e.Kind.Error()
+	}
+	return e.Op + ": " + e.Kind.Error() + " " + e.Err.Error()
+}
+
+func (e *OpError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+/**
+ * 给错误加上种类，已经有种类的错误和不认识的错误原样返回
+ * @author abram
+ * @param op read 或write
+ * @param err 原始错误
+ * @return error
+ */
+func classifyError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var opErr *OpError
+	if errors.As(err, &opErr) {
+		return err
+	}
+	var kind error
+	var sizeErr *SizeError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &sizeErr):
+		kind = sizeErr.Err
+		if kind == ErrNegativeLength {
+			kind = ErrMalformedHeader
+		}
+	case errors.Is(err, errShortFrame):
+		kind = ErrMalformedHeader
+	case errors.Is(err, syscall.ECONNRESET):
+		kind = ErrPeerReset
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, ErrConnectionClosed) || errors.Is(err, errReadInterrupted):
+		kind = ErrConnectionClosed
+	case errors.As(err, &netErr) && netErr.Timeout():
+		kind = ErrTimeout
+	default:
+		return err
+	}
+	return &OpError{Op: op, Kind: kind, Err: err}
+}
+
+// 是否是正常的断开，对方或本地关闭了连接
+func IsNormalClose(err error) bool {
+	return err == nil || errors.Is(err, ErrConnectionClosed)
+}
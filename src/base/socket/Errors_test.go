@@ -0,0 +1,139 @@
+package socket
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestClassifyError(t *testing.T) {
+	cases := []struct {
+		err  error
+		kind error
+	}{
+		{io.EOF, ErrConnectionClosed},
+		{net.ErrClosed, ErrConnectionClosed},
+		{&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, ErrPeerReset},
+		{&net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}, ErrTimeout},
+		{&SizeError{Err: ErrFrameTooLarge, Size: 100, Limit: 10}, ErrFrameTooLarge},
+		{&SizeError{Err: ErrNegativeLength, Size: -1, Limit: 10}, ErrMalformedHeader},
+	}
+	for _, c := range cases {
+		err := classifyError("read", c.err)
+		if !errors.Is(err, c.kind) {
+			t.Errorf("%v: expected kind %v, got %v", c.err, c.kind, err)
+		}
+		if !errors.Is(err, c.err) {
+			t.Errorf("%v: original error lost", c.err)
+		}
+	}
+
+	var sizeErr *SizeError
+	if !errors.As(classifyError("read", &SizeError{Err: ErrBodyTooLarge}), &sizeErr) {
+		t.Error("SizeError should still be reachable with errors.As")
+	}
+	unknown := errors.New("unknown")
+	if classifyError("read", unknown) != unknown {
+		t.Error("unknown errors should be returned unchanged")
+	}
+	if !IsNormalClose(classifyError("read", io.EOF)) || IsNormalClose(classifyError("read", &SizeError{Err: ErrFrameTooLarge})) {
+		t.Error("IsNormalClose misclassified")
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	// 消息头不完整
+	transport := &memoryTransport{}
+	transport.Write([]byte{0, 0})
+	if _, err := NewDefaultCodec(transport).Decode(); !errors.Is(err, ErrMalformedHeader) {
+		t.Fatalf("expected ErrMalformedHeader, got %v", err)
+	}
+
+	// 消息之间断开是正常关闭
+	transport = &memoryTransport{}
+	if _, err := NewDefaultCodec(transport).Decode(); !IsNormalClose(err) {
+		t.Fatalf("expected normal close, got %v", err)
+	}
+
+	// 长度为负数
+	transport = &memoryTransport{}
+	transport.Write([]byte{0, 0, 0, 0, 1, 0xff, 0xff, 0xff, 0xff})
+	if _, err := NewDefaultCodec(transport).Decode(); !errors.Is(err, ErrMalformedHeader) || !errors.Is(err, ErrNegativeLength) {
+		t.Fatalf("expected ErrMalformedHeader, got %v", err)
+	}
+
+	// 数据帧比消息头短，不能接着读下一帧
+	transport = &memoryTransport{}
+	transport.Write([]byte{0, 0, 0, 2, 0, 0})
+	transport.Write([]byte{0, 0, 0, 9, 0, 0, 0, 0, 1, 0, 0, 0, 0})
+	if _, err := NewDefaultCodec(NewFramedTransport(transport)).Decode(); !errors.Is(err, ErrMalformedHeader) {
+		t.Fatalf("expected ErrMalformedHeader for a short frame, got %v", err)
+	}
+
+	// 完整的数据帧可以连续解码
+	transport = &memoryTransport{}
+	for i := 0; i < 2; i++ {
+		transport.Write([]byte{0, 0, 0, 9, 0, 0, 0, 0, 1, 0, 0, 0, 0})
+	}
+	codec := NewDefaultCodec(NewFramedTransport(transport))
+	for i := 0; i < 2; i++ {
+		if protoPack, err := codec.Decode(); err != nil || protoPack.Id != 1 {
+			t.Fatalf("decode %d: %v", i, err)
+		}
+	}
+}
+
+func TestServerErrorHandler(t *testing.T) {
+	addr := freeAddr(t)
+	errs := make(chan error, 2)
+	srvConfig := newTestConfig(addr)
+	srvConfig.ErrorHandler = func(channel IChannel, err error) { errs <- err }
+	srvConfig.MaxFrameSize = 64
+	server := startTestServer(t, srvConfig)
+	defer server.Stop()
+
+	send := func(data []byte) error {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write(data)
+		conn.Close()
+		select {
+		case err := <-errs:
+			return err
+		case <-time.After(time.Second):
+			t.Fatal("ErrorHandler not called")
+		}
+		return nil
+	}
+
+	if err := send(nil); !IsNormalClose(err) {
+		t.Errorf("expected normal close, got %v", err)
+	}
+	if err := send([]byte{0, 0, 1, 0}); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("expected ErrFrameTooLarge, got %v", err)
+	}
+	if err := send([]byte{0, 0, 0, 2, 0, 0}); !errors.Is(err, ErrMalformedHeader) {
+		t.Errorf("expected ErrMalformedHeader, got %v", err)
+	}
+}
+
+func TestChannelWriteClosed(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	socket, _ := NewSocketFromConnTimeout(client, 0)
+	transport := NewFramedTransport(socket)
+	channel := newDefaultChannel(socket, transport, NewDefaultCodec(transport))
+	channel.Close()
+	if err := channel.Write(ProtoPack{Id: 1}); !errors.Is(err, ErrConnectionClosed) {
+		t.Fatalf("expected ErrConnectionClosed, got %v", err)
+	}
+	if err := channel.Write("not a ProtoPack"); err != ErrInvalidData {
+		t.Fatalf("expected ErrInvalidData, got %v", err)
+	}
+}
@@ -3,35 +3,111 @@ package socket
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"io"
+	"strconv"
 )
 
+const (
+	DefaultMaxFrameSize = 16 << 20 // 默认的最大数据帧长度
+)
+
+var (
+	ErrFrameTooLarge  = errors.New("数据帧超过最大长度。")
+	ErrBodyTooLarge   = errors.New("消息体超过最大长度。")
+	ErrNegativeLength = errors.New("长度不能为负数。")
+)
+
+/**
+ * 长度超过限制或为负数的错误，可以用errors.Is 和ErrFrameTooLarge、
+ * ErrBodyTooLarge、ErrNegativeLength 比较
+ * @author abram
+ */
+type SizeError struct {
+	Err   error // ErrFrameTooLarge、ErrBodyTooLarge 或ErrNegativeLength
+	Size  int64 // 读到的长度
+	Limit int   // 允许的最大长度
+}
+
+func (e *SizeError) Error() string {
+	return e.Err.Error() + " size=" + strconv.FormatInt(e.Size, 10) + " limit=" + strconv.Itoa(e.Limit)
+}
+
+func (e *SizeError) Unwrap() error {
+	return e.Err
+}
+
+// 是长度错误时交给handler 处理
+func reportOversize(guard *panicGuard, channel IChannel, err error, handler func(channel IChannel, err error)) {
+	var sizeErr *SizeError
+	if handler != nil && errors.As(err, &sizeErr) {
+		guard.run(channel, func() { handler(channel, err) })
+	}
+}
+
 type FramedTransport struct {
-	socket      ITransport // 实际类型为Socket
-	writeBuffer *bytes.Buffer
-	readBuffer  *bytes.Buffer
+	socket       ITransport // 实际类型为Socket
+	writeBuffer  *bytes.Buffer
+	readBuffer   *bytes.Buffer
+	maxFrameSize int
+	batch        *bytes.Buffer // 合并写的缓冲，endBatch 后保留下来重复使用
+	batching     bool          // 为true 时Flush 只把数据帧放进batch，endBatch 时一次写出
+	inMessage    bool          // 当前消息已经从readBuffer 读过数据，读完这一帧不能接着读下一帧
+	messages     bool          // 调用过beginMessage，按消息检查数据帧边界
+	instrument   Instrumentation
 }
 
 //socket 的世界类型为Socket
 func NewFramedTransport(socket ITransport) *FramedTransport {
 	writeBuf := make([]byte, 0, 1024)
 	readBuf := make([]byte, 0, 1024)
-	return &FramedTransport{socket: socket, writeBuffer: bytes.NewBuffer(writeBuf), readBuffer: bytes.NewBuffer(readBuf)}
+	return &FramedTransport{socket: socket, writeBuffer: bytes.NewBuffer(writeBuf), readBuffer: bytes.NewBuffer(readBuf),
+		maxFrameSize: DefaultMaxFrameSize}
+}
+
+// 设置最大数据帧长度，小于等于0 时使用DefaultMaxFrameSize
+func (transport *FramedTransport) SetMaxFrameSize(size int) {
+	if size <= 0 {
+		size = DefaultMaxFrameSize
+	}
+	transport.maxFrameSize = size
+}
+
+// 设置监控，每读写一个数据帧调用一次，nil 表示不统计
+func (transport *FramedTransport) SetInstrumentation(instrumentation Instrumentation) {
+	transport.instrument = instrumentation
 }
 
 func (transport *FramedTransport) Read(buf []byte) (int, error) {
 	if transport.readBuffer.Len() > 0 {
 		got, err := transport.readBuffer.Read(buf)
 		if got > 0 {
+			transport.inMessage = transport.messages
 			return got, err
 		}
 	}
+	// 一个消息不能跨越数据帧，否则后面的数据都会错位
+	if transport.inMessage {
+		return 0, errShortFrame
+	}
 
-	transport.readFrame()
+	// 跳过空的数据帧
+	for transport.readBuffer.Len() == 0 {
+		if _, err := transport.readFrame(); err != nil {
+			return 0, err
+		}
+	}
 	got, err := transport.readBuffer.Read(buf)
+	transport.inMessage = transport.messages
 	return got, err
 }
 
+// 开始读一个消息，之后这个消息读完当前数据帧还不够时Read 返回错误，而不是读下一帧
+func (transport *FramedTransport) beginMessage() {
+	transport.messages = true
+	transport.inMessage = transport.readBuffer.Len() > 0
+}
+
 func (transport *FramedTransport) Write(buf []byte) (int, error) {
 	n, err := transport.writeBuffer.Write(buf)
 	return n, err
@@ -42,6 +118,15 @@ func (transport *FramedTransport) Flush() error {
 	buf := []byte{0, 0, 0, 0}
 	binary.BigEndian.PutUint32(buf, uint32(size))
 
+	if transport.batching {
+		transport.batch.Write(buf)
+		_, err := transport.writeBuffer.WriteTo(transport.batch)
+		if err == nil && transport.instrument != nil {
+			transport.instrument.FrameWritten(4 + size)
+		}
+		return err
+	}
+
 	if _, err := transport.socket.Write(buf); err != nil {
 		return err
 	}
@@ -51,17 +136,60 @@ func (transport *FramedTransport) Flush() error {
 		}
 	}
 	err := transport.socket.Flush()
+	if err == nil && transport.instrument != nil {
+		transport.instrument.FrameWritten(4 + size)
+	}
 	return err
 }
 
+// 开始合并写，之后Flush 的数据帧在endBatch 时一次写给socket
+func (transport *FramedTransport) beginBatch() {
+	if transport.batch == nil {
+		transport.batch = new(bytes.Buffer)
+	}
+	transport.batch.Reset()
+	transport.batching = true
+}
+
+// 已经合并的数据长度
+func (transport *FramedTransport) batchSize() int {
+	if !transport.batching {
+		return 0
+	}
+	return transport.batch.Len()
+}
+
+// 结束合并写，把合并的数据帧写出去
+func (transport *FramedTransport) endBatch() error {
+	if !transport.batching {
+		return nil
+	}
+	transport.batching = false
+	batch := transport.batch
+	if batch.Len() == 0 {
+		return nil
+	}
+	// WriteTo 写完后buffer 为空，底层数组留给下一次合并
+	if _, err := batch.WriteTo(transport.socket); err != nil {
+		return err
+	}
+	return transport.socket.Flush()
+}
+
 func (transport *FramedTransport) readFrame() (int, error) {
 	buf := []byte{0, 0, 0, 0}
 	if _, err := io.ReadFull(transport.socket, buf); err != nil {
 		return 0, err
 	}
 
-	size := int(binary.BigEndian.Uint32(buf))
+	size := binary.BigEndian.Uint32(buf)
+	if int64(size) > int64(transport.maxFrameSize) {
+		return 0, &SizeError{Err: ErrFrameTooLarge, Size: int64(size), Limit: transport.maxFrameSize}
+	}
 	if size == 0 {
+		if transport.instrument != nil {
+			transport.instrument.FrameRead(4)
+		}
 		return 0, nil
 	}
 
@@ -70,7 +198,10 @@ func (transport *FramedTransport) readFrame() (int, error) {
 		return n, err
 	}
 	transport.readBuffer = bytes.NewBuffer(buf2)
-	return size, nil
+	if transport.instrument != nil {
+		transport.instrument.FrameRead(4 + int(size))
+	}
+	return int(size), nil
 }
 
 func (transport *FramedTransport) Open() error {
@@ -0,0 +1,92 @@
+package socket
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMaxFrameSize(t *testing.T) {
+	addr := freeAddr(t)
+	oversize := make(chan error, 1)
+	srvConfig := newTestConfig(addr)
+	srvConfig.MaxFrameSize = 1024
+	srvConfig.OversizeHandler = func(channel IChannel, err error) { oversize <- err }
+	startTestServer(t, srvConfig)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// 声称有4G 的数据帧
+	if _, err := conn.Write([]byte{0xff, 0xff, 0xff, 0xff}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-oversize:
+		var sizeErr *SizeError
+		if !errors.Is(err, ErrFrameTooLarge) || !errors.As(err, &sizeErr) || sizeErr.Limit != 1024 {
+			t.Fatalf("unexpected error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OversizeHandler not called")
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("connection should be closed, got %v", err)
+	}
+}
+
+func TestNegativeBodyLength(t *testing.T) {
+	transport := &memoryTransport{}
+	// 消息头之后的消息体长度为-1
+	transport.Write([]byte{0, 0, 0, 0, 1, 0xff, 0xff, 0xff, 0xff})
+	codec := NewDefaultCodec(transport)
+	if _, err := codec.Decode(); !errors.Is(err, ErrNegativeLength) {
+		t.Fatalf("expected ErrNegativeLength, got %v", err)
+	}
+
+	transport.Reset()
+	transport.Write([]byte{0, 0, 0, 0, 1, 0, 0, 0x10, 0})
+	codec.(*DefaultCodec).SetMaxBodySize(1024)
+	if _, err := codec.Decode(); !errors.Is(err, ErrBodyTooLarge) {
+		t.Fatalf("expected ErrBodyTooLarge, got %v", err)
+	}
+}
+
+func TestBatchBufferReused(t *testing.T) {
+	socket := &memoryTransport{}
+	transport := NewFramedTransport(socket)
+	for round := 0; round < 2; round++ {
+		transport.beginBatch()
+		transport.Write([]byte{1, 2})
+		transport.Flush()
+		transport.Write([]byte{3})
+		transport.Flush()
+		if socket.Len() != 0 || transport.batchSize() != 11 {
+			t.Fatalf("frames written before endBatch, socket %d batch %d", socket.Len(), transport.batchSize())
+		}
+		if err := transport.endBatch(); err != nil {
+			t.Fatal(err)
+		}
+		if socket.Len() != 11 || transport.batchSize() != 0 {
+			t.Fatalf("batch not written, socket %d", socket.Len())
+		}
+		socket.Reset()
+	}
+	if transport.batch == nil || transport.batch.Cap() == 0 {
+		t.Fatal("batch buffer should be kept for the next batch")
+	}
+
+	// 不合并时直接写给socket
+	transport.Write([]byte{1})
+	transport.Flush()
+	if socket.Len() != 5 {
+		t.Fatalf("frame not written directly, socket %d", socket.Len())
+	}
+}
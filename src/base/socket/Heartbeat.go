@@ -0,0 +1,166 @@
+package socket
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const (
+	HeartbeatPingId int16 = -1 // 心跳请求的消息id，保留给框架使用
+	HeartbeatPongId int16 = -2 // 心跳响应的消息id，保留给框架使用
+)
+
+// 是否是心跳消息，心跳不算连接上的写操作，不会推迟WriterIdle
+func isHeartbeat(id int16) bool {
+	return id == HeartbeatPingId || id == HeartbeatPongId
+}
+
+// 空闲状态
+type IdleState int
+
+const (
+	ReaderIdle IdleState = iota + 1 // 一段时间没有读到数据
+	WriterIdle                      // 一段时间没有写出数据
+	AllIdle                         // 一段时间既没有读也没有写
+)
+
+func (state IdleState) String() string {
+	switch state {
+	case ReaderIdle:
+		return "ReaderIdle"
+	case WriterIdle:
+		return "WriterIdle"
+	case AllIdle:
+		return "AllIdle"
+	}
+	return "Unknown"
+}
+
+/**
+ * 心跳和空闲检测配置，时间为0 的项不启用
+ * @author abram
+ */
+type HeartbeatConfig struct {
+	Interval  time.Duration // 发送心跳的间隔
+	MaxMissed int           // 连续多少个心跳没有收到响应就关闭连接
+	ReadIdle  time.Duration // 多久没有读到数据触发ReaderIdle
+	WriteIdle time.Duration // 多久没有写出数据触发WriterIdle
+	AllIdle   time.Duration // 多久没有读写数据触发AllIdle
+}
+
+/**
+ * 生成一个默认的心跳配置：每30秒发送一次心跳，连续3次没有响应关闭连接
+ * @author abram
+ * @return HeartbeatConfig
+ */
+func NewHeartbeatConfig() *HeartbeatConfig {
+	return &HeartbeatConfig{Interval: 30 * time.Second, MaxMissed: 3}
+}
+
+// 检测的时间间隔，取所有启用项中最小的一半
+func (config *HeartbeatConfig) tick() time.Duration {
+	var tick time.Duration
+	for _, d := range []time.Duration{config.Interval, config.ReadIdle, config.WriteIdle, config.AllIdle} {
+		if d > 0 && (tick == 0 || d < tick) {
+			tick = d
+		}
+	}
+	tick /= 2
+	if tick > 0 && tick < 10*time.Millisecond {
+		tick = 10 * time.Millisecond
+	}
+	return tick
+}
+
+// 一个连接的心跳协程
+type heartbeat struct {
+	config      *HeartbeatConfig
+	channel     *DefaultChannel
+	idleHandler func(channel IChannel, state IdleState)
+	guard       *panicGuard
+	stop        chan struct{}
+	lastPing    time.Time
+	firedRead   int64 // 触发ReaderIdle 时的lastRead，同一段空闲只触发一次
+	firedWrite  int64
+	firedAll    int64
+}
+
+/**
+ * 为连接启动心跳协程，连接断开时调用返回的函数停止
+ * @author abram
+ * @param channel 连接
+ * @param config 心跳配置，nil 时不启动
+ * @param idleHandler 空闲事件处理函数，可以为nil
+ * @param guard 空闲事件处理函数panic 时的处理
+ * @return 停止函数
+ */
+func startHeartbeat(channel *DefaultChannel, config *HeartbeatConfig, idleHandler func(channel IChannel, state IdleState), guard *panicGuard) func() {
+	if config == nil || config.tick() == 0 {
+		return func() {}
+	}
+	hb := &heartbeat{
+		config:      config,
+		channel:     channel,
+		idleHandler: idleHandler,
+		guard:       guard,
+		stop:        make(chan struct{}),
+		lastPing:    time.Now(),
+	}
+	go hb.run()
+	return func() {
+		close(hb.stop)
+	}
+}
+
+func (hb *heartbeat) run() {
+	ticker := time.NewTicker(hb.config.tick())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-hb.stop:
+			return
+		case now := <-ticker.C:
+			if !hb.check(now) {
+				return
+			}
+		}
+	}
+}
+
+// 检测一次，连接被关闭时返回false
+func (hb *heartbeat) check(now time.Time) bool {
+	channel := hb.channel
+	config := hb.config
+
+	if config.Interval > 0 && now.Sub(hb.lastPing) >= config.Interval {
+		if config.MaxMissed > 0 && int(atomic.LoadInt32(&channel.missedPongs)) >= config.MaxMissed {
+			channel.closeWith(&OpError{Op: "read", Kind: ErrTimeout, Err: errHeartbeatTimeout})
+			return false
+		}
+		hb.lastPing = now
+		atomic.AddInt32(&channel.missedPongs, 1)
+		channel.Write(ProtoPack{Id: HeartbeatPingId})
+	}
+
+	lastRead := atomic.LoadInt64(&channel.lastRead)
+	lastWrite := atomic.LoadInt64(&channel.lastWrite)
+	hb.fire(now, config.ReadIdle, lastRead, &hb.firedRead, ReaderIdle)
+	hb.fire(now, config.WriteIdle, lastWrite, &hb.firedWrite, WriterIdle)
+	lastActive := lastRead
+	if lastWrite > lastActive {
+		lastActive = lastWrite
+	}
+	hb.fire(now, config.AllIdle, lastActive, &hb.firedAll, AllIdle)
+	return true
+}
+
+// last 之后空闲了idle 时间就触发一次state 事件
+func (hb *heartbeat) fire(now time.Time, idle time.Duration, last int64, fired *int64, state IdleState) {
+	if idle <= 0 || *fired == last || now.UnixNano()-last < int64(idle) {
+		return
+	}
+	*fired = last
+	if hb.idleHandler != nil {
+		hb.guard.call(hb.channel, func(channel IChannel) { hb.idleHandler(channel, state) })
+	}
+}
@@ -0,0 +1,172 @@
+package socket
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatIdle(t *testing.T) {
+	addr := freeAddr(t)
+	idle := make(chan IdleState, 4)
+	srvConfig := newTestConfig(addr)
+	srvConfig.Heartbeat = &HeartbeatConfig{ReadIdle: 50 * time.Millisecond}
+	srvConfig.IdleHandler = func(channel IChannel, state IdleState) { idle <- state }
+	startTestServer(t, srvConfig)
+
+	cliConfig := newTestConfig(addr)
+	client, err := NewClient(cliConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	select {
+	case state := <-idle:
+		if state != ReaderIdle {
+			t.Fatalf("unexpected idle state %v", state)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ReaderIdle not fired")
+	}
+}
+
+func TestHeartbeatKeepAlive(t *testing.T) {
+	addr := freeAddr(t)
+	srvConfig := newTestConfig(addr)
+	srvConfig.MessageHandler = func(channel IChannel, protoPack *ProtoPack) {
+		t.Errorf("heartbeat delivered to MessageHandler: %d", protoPack.Id)
+	}
+	startTestServer(t, srvConfig)
+
+	cliConfig := newTestConfig(addr)
+	cliConfig.Heartbeat = &HeartbeatConfig{Interval: 20 * time.Millisecond, MaxMissed: 2}
+	client, err := NewClient(cliConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	select {
+	case <-client.Done():
+		t.Fatal("connection closed although pongs were received")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestHeartbeatMissedPongs(t *testing.T) {
+	// 只接受连接不响应心跳的服务端
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			defer conn.Close()
+			buf := make([]byte, 1024)
+			for {
+				if _, err := conn.Read(buf); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	cliConfig := newTestConfig(l.Addr().String())
+	cliConfig.Heartbeat = &HeartbeatConfig{Interval: 20 * time.Millisecond, MaxMissed: 2}
+	client, err := NewClient(cliConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	select {
+	case <-client.Done():
+	case <-time.After(time.Second):
+		t.Fatal("connection not closed after missed pongs")
+	}
+	if err := client.Wait(); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+}
+
+func TestHeartbeatMissedPongsServer(t *testing.T) {
+	addr := freeAddr(t)
+	errs := make(chan error, 1)
+	srvConfig := newTestConfig(addr)
+	srvConfig.Heartbeat = &HeartbeatConfig{Interval: 20 * time.Millisecond, MaxMissed: 2}
+	srvConfig.ErrorHandler = func(channel IChannel, err error) { errs <- err }
+	startTestServer(t, srvConfig)
+
+	// 只连接不响应心跳的客户端
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go io.Copy(io.Discard, conn)
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrTimeout) || IsNormalClose(err) {
+			t.Fatalf("expected ErrTimeout, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("connection not closed after missed pongs")
+	}
+}
+
+func TestHeartbeatWriterIdle(t *testing.T) {
+	addr := freeAddr(t)
+	srvConfig := newTestConfig(addr)
+	startTestServer(t, srvConfig)
+
+	// 心跳比WriteIdle 更频繁时WriterIdle 仍然触发，IdleHandler 的panic 被PanicHandler 收到
+	idle := make(chan IdleState, 4)
+	panics := make(chan interface{}, 4)
+	cliConfig := newTestConfig(addr)
+	cliConfig.Heartbeat = &HeartbeatConfig{Interval: 20 * time.Millisecond, WriteIdle: 60 * time.Millisecond}
+	cliConfig.IdleHandler = func(channel IChannel, state IdleState) {
+		idle <- state
+		panic("idle")
+	}
+	cliConfig.PanicHandler = func(channel IChannel, protoPack *ProtoPack, err interface{}, stack []byte) { panics <- err }
+	client, err := NewClient(cliConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	select {
+	case state := <-idle:
+		if state != WriterIdle {
+			t.Fatalf("unexpected idle state %v", state)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("WriterIdle not fired")
+	}
+	select {
+	case err := <-panics:
+		if err != "idle" {
+			t.Fatalf("unexpected panic %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("IdleHandler panic not reported")
+	}
+}
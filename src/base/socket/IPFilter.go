@@ -0,0 +1,177 @@
+package socket
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+)
+
+/**
+ * 读取配置的接口，config.ConfigContainer 实现了这个接口
+ * @author abram
+ */
+type ConfigSource interface {
+	String(key string) string
+	DIY(key string) (interface{}, error)
+}
+
+// 一组允许和禁止的网段
+type ipRules struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+/**
+ * IP 过滤器，先看禁止列表，再看允许列表，允许列表为空时表示都允许。
+ * 规则可以在运行时用Update 或Load 替换，不影响正在检查的连接
+ * @author abram
+ */
+type IPFilter struct {
+	rules atomic.Value // *ipRules
+}
+
+/**
+ * 生成IP 过滤器
+ * @author abram
+ * @param allow 允许的网段，比如192.168.1.0/24，单个IP 也可以
+ * @param deny 禁止的网段
+ * @return IPFilter
+ */
+func NewIPFilter(allow, deny []string) (*IPFilter, error) {
+	filter := &IPFilter{}
+	if err := filter.Update(allow, deny); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+/**
+ * 从配置中生成IP 过滤器
+ * @author abram
+ * @param conf 配置，比如config.NewConfig 的返回值
+ * @param allowKey 允许列表的key，值为逗号分隔的网段或数组
+ * @param denyKey 禁止列表的key
+ * @return IPFilter
+ */
+func LoadIPFilter(conf ConfigSource, allowKey, denyKey string) (*IPFilter, error) {
+	filter := &IPFilter{}
+	if err := filter.Load(conf, allowKey, denyKey); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// 解析网段，没有掩码的按单个IP 处理
+func parseCIDRs(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("socket: invalid IP %q", s)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// 替换规则
+func (filter *IPFilter) Update(allow, deny []string) error {
+	allowNets, err := parseCIDRs(allow)
+	if err != nil {
+		return err
+	}
+	denyNets, err := parseCIDRs(deny)
+	if err != nil {
+		return err
+	}
+	filter.rules.Store(&ipRules{allow: allowNets, deny: denyNets})
+	return nil
+}
+
+// 从配置中重新读取规则，比如配置文件修改之后
+func (filter *IPFilter) Load(conf ConfigSource, allowKey, denyKey string) error {
+	return filter.Update(configList(conf, allowKey), configList(conf, denyKey))
+}
+
+// 读取列表配置，支持逗号分隔的字符串和数组
+func configList(conf ConfigSource, key string) []string {
+	if key == "" {
+		return nil
+	}
+	if s := conf.String(key); s != "" {
+		return strings.Split(s, ",")
+	}
+	v, err := conf.DIY(key)
+	if err != nil {
+		return nil
+	}
+	var list []string
+	switch v := v.(type) {
+	case []string:
+		list = v
+	case []interface{}:
+		for _, item := range v {
+			list = append(list, fmt.Sprint(item))
+		}
+	}
+	return list
+}
+
+// 判断地址是否允许。取不到IP 的地址（nil、unix 套接字等）在配置了允许列表时拒绝，
+// 否则允许，避免允许列表因为地址解析失败而放行
+func (filter *IPFilter) Allow(addr net.Addr) bool {
+	var ip net.IP
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UDPAddr:
+		ip = addr.IP
+	case *net.IPAddr:
+		ip = addr.IP
+	case nil:
+	default:
+		if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+			ip = net.ParseIP(host)
+		}
+	}
+	return filter.AllowIP(ip)
+}
+
+// 判断IP 是否允许，ip 为nil 时只有没有允许列表才允许
+func (filter *IPFilter) AllowIP(ip net.IP) bool {
+	rules, _ := filter.rules.Load().(*ipRules)
+	if rules == nil {
+		return true
+	}
+	for _, ipNet := range rules.deny {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+	if len(rules.allow) == 0 {
+		return true
+	}
+	for _, ipNet := range rules.allow {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
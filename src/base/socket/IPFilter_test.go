@@ -0,0 +1,94 @@
+package socket
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// 模拟config.ConfigContainer
+type testConfigSource map[string]interface{}
+
+func (conf testConfigSource) String(key string) string {
+	s, _ := conf[key].(string)
+	return s
+}
+
+func (conf testConfigSource) DIY(key string) (interface{}, error) {
+	if v, ok := conf[key]; ok {
+		return v, nil
+	}
+	return nil, errors.New("not exist")
+}
+
+// 不是TCP/UDP 的地址类型
+type testAddr string
+
+func (addr testAddr) Network() string { return "test" }
+func (addr testAddr) String() string  { return string(addr) }
+
+func TestIPFilter(t *testing.T) {
+	conf := testConfigSource{
+		"admin.allow": "10.0.0.0/8, 192.168.1.0/24",
+		"admin.deny":  []interface{}{"10.0.0.1"},
+	}
+	filter, err := LoadIPFilter(conf, "admin.allow", "admin.deny")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{"10.1.2.3": true, "192.168.1.9": true, "10.0.0.1": false, "8.8.8.8": false}
+	for ip, want := range cases {
+		if got := filter.AllowIP(net.ParseIP(ip)); got != want {
+			t.Errorf("%s: got %v, want %v", ip, got, want)
+		}
+	}
+	// 配置了允许列表时取不到IP 的地址要拒绝
+	if filter.Allow(&net.UnixAddr{Name: "/tmp/x.sock", Net: "unix"}) || filter.Allow(nil) {
+		t.Error("addresses without an IP should be denied by an allowlist")
+	}
+	if !filter.Allow(testAddr("10.1.2.3:80")) || filter.Allow(testAddr("8.8.8.8:80")) {
+		t.Error("other address types should be filtered by their host")
+	}
+	denyOnly, _ := NewIPFilter(nil, []string{"10.0.0.1"})
+	if !denyOnly.Allow(&net.UnixAddr{Name: "/tmp/x.sock", Net: "unix"}) || !denyOnly.Allow(nil) {
+		t.Error("addresses without an IP should pass a deny-only filter")
+	}
+	if _, err := NewIPFilter([]string{"10.0.0.0/33"}, nil); err == nil {
+		t.Error("invalid CIDR should fail")
+	}
+}
+
+func TestServerIPFilter(t *testing.T) {
+	addr := freeAddr(t)
+	filter, _ := NewIPFilter(nil, []string{"127.0.0.0/8"})
+	srvConfig := newTestConfig(addr)
+	srvConfig.IPFilter = filter
+	server := startTestServer(t, srvConfig)
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("denied connection should be closed, got %v", err)
+	}
+	conn.Close()
+
+	// 运行时放开
+	filter.Update([]string{"127.0.0.1"}, nil)
+	conn, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for i := 0; i < 100 && server.ChannelCount() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if server.ChannelCount() != 1 {
+		t.Fatal("allowed connection was not accepted")
+	}
+}
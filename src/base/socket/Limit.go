@@ -0,0 +1,283 @@
+package socket
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// 消息频率超限断开连接时交给Config.ErrorHandler 的错误
+var ErrRateLimited = errors.New("消息频率超过限制。")
+
+// 超过限制时的处理方式
+type LimitAction int
+
+const (
+	LimitReject     LimitAction = iota // 拒绝连接或丢弃消息
+	LimitDelay                         // 等到允许为止，期间暂停接受连接或读取数据
+	LimitDisconnect                    // 断开连接
+)
+
+// 触发的限制
+type LimitKind int
+
+const (
+	LimitMaxConnections LimitKind = iota // 总连接数
+	LimitPerIP                           // 单个IP 的连接数
+	LimitAcceptRate                      // 接受连接的频率
+	LimitMessageRate                     // 单个连接的消息频率
+)
+
+func (kind LimitKind) String() string {
+	switch kind {
+	case LimitMaxConnections:
+		return "MaxConnections"
+	case LimitPerIP:
+		return "PerIP"
+	case LimitAcceptRate:
+		return "AcceptRate"
+	case LimitMessageRate:
+		return "MessageRate"
+	}
+	return "Unknown"
+}
+
+/**
+ * 超过限制的事件，交给LimitConfig.Handler 记录
+ * @author abram
+ */
+type LimitEvent struct {
+	Kind    LimitKind
+	Action  LimitAction
+	Addr    net.Addr // 对方的地址，可能为nil
+	Channel IChannel // 消息频率超限的连接，其他情况为nil
+}
+
+/**
+ * 连接数和消息频率限制，值为0 表示不限制。
+ * 连接数超限时总是拒绝；接受频率超限支持LimitReject 和LimitDelay；
+ * 消息频率按令牌桶计算，支持全部三种处理方式
+ * @author abram
+ */
+type LimitConfig struct {
+	MaxConnections      int                    // 最大连接数
+	MaxConnectionsPerIP int                    // 每个IP 的最大连接数
+	AcceptRate          float64                // 每秒最多接受多少个连接
+	AcceptBurst         int                    // 接受连接的突发数量，0 时取AcceptRate
+	AcceptAction        LimitAction            // 接受频率超限时的处理方式
+	MessageRate         float64                // 每个连接每秒最多处理多少个消息
+	MessageBurst        int                    // 消息的突发数量，0 时取MessageRate
+	MessageAction       LimitAction            // 消息频率超限时的处理方式
+	Handler             func(event LimitEvent) // 超过限制时调用，可以为nil
+}
+
+/**
+ * 生成不做任何限制的配置
+ * @author abram
+ * @return LimitConfig
+ */
+func NewLimitConfig() *LimitConfig {
+	return &LimitConfig{}
+}
+
+// 令牌桶
+type tokenBucket struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst <= 0 {
+		burst = int(rate)
+		if burst < 1 {
+			burst = 1
+		}
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+func (bucket *tokenBucket) refill(now time.Time) {
+	bucket.tokens += now.Sub(bucket.last).Seconds() * bucket.rate
+	if bucket.tokens > bucket.burst {
+		bucket.tokens = bucket.burst
+	}
+	bucket.last = now
+}
+
+// 有令牌时取走一个并返回true
+func (bucket *tokenBucket) allow() bool {
+	bucket.lock.Lock()
+	defer bucket.lock.Unlock()
+	bucket.refill(time.Now())
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// 预定一个令牌，返回要等待的时间
+func (bucket *tokenBucket) reserve() time.Duration {
+	bucket.lock.Lock()
+	defer bucket.lock.Unlock()
+	bucket.refill(time.Now())
+	bucket.tokens--
+	if bucket.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-bucket.tokens / bucket.rate * float64(time.Second))
+}
+
+// 地址中的IP，不是IP 地址时用整个地址
+func remoteIP(addr net.Addr) string {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP.String()
+	case *net.UDPAddr:
+		return addr.IP.String()
+	case nil:
+		return ""
+	}
+	return addr.String()
+}
+
+// 传输层对方的地址
+func transportRemoteAddr(transport ITransport) net.Addr {
+	switch v := transport.(type) {
+	case interface{ RemoteAddr() net.Addr }:
+		return v.RemoteAddr()
+	case connHolder:
+		if conn := v.Conn(); conn != nil {
+			return conn.RemoteAddr()
+		}
+	}
+	return nil
+}
+
+// Server 的限制器
+type limiter struct {
+	config      *LimitConfig
+	acceptLimit *tokenBucket
+	guard       *panicGuard // LimitConfig.Handler panic 时的处理
+
+	lock  sync.Mutex
+	total int
+	perIP map[string]int
+}
+
+func newLimiter(config *LimitConfig, guard *panicGuard) *limiter {
+	if config == nil {
+		return nil
+	}
+	l := &limiter{config: config, guard: guard, perIP: make(map[string]int)}
+	if config.AcceptRate > 0 {
+		l.acceptLimit = newTokenBucket(config.AcceptRate, config.AcceptBurst)
+	}
+	return l
+}
+
+func (l *limiter) report(event LimitEvent) {
+	if l.config.Handler != nil {
+		l.guard.run(event.Channel, func() { l.config.Handler(event) })
+	}
+}
+
+// 接受连接之前调用，LimitDelay 时等到频率允许为止
+func (l *limiter) waitAccept() {
+	if l == nil || l.acceptLimit == nil || l.config.AcceptAction != LimitDelay {
+		return
+	}
+	if wait := l.acceptLimit.reserve(); wait > 0 {
+		l.report(LimitEvent{Kind: LimitAcceptRate, Action: LimitDelay})
+		time.Sleep(wait)
+	}
+}
+
+// 判断是否可以接受这个连接，可以时计入连接数，之后要调用release
+func (l *limiter) admit(addr net.Addr) bool {
+	if l == nil {
+		return true
+	}
+	if l.acceptLimit != nil && l.config.AcceptAction != LimitDelay && !l.acceptLimit.allow() {
+		l.report(LimitEvent{Kind: LimitAcceptRate, Action: LimitReject, Addr: addr})
+		return false
+	}
+
+	ip := remoteIP(addr)
+	l.lock.Lock()
+	kind := LimitKind(-1)
+	if l.config.MaxConnections > 0 && l.total >= l.config.MaxConnections {
+		kind = LimitMaxConnections
+	} else if l.config.MaxConnectionsPerIP > 0 && ip != "" && l.perIP[ip] >= l.config.MaxConnectionsPerIP {
+		kind = LimitPerIP
+	} else {
+		l.total++
+		if ip != "" {
+			l.perIP[ip]++
+		}
+	}
+	l.lock.Unlock()
+
+	if kind >= 0 {
+		l.report(LimitEvent{Kind: kind, Action: LimitReject, Addr: addr})
+		return false
+	}
+	return true
+}
+
+// 连接关闭后释放连接数
+func (l *limiter) release(addr net.Addr) {
+	if l == nil {
+		return
+	}
+	ip := remoteIP(addr)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.total--
+	if ip != "" {
+		if l.perIP[ip] <= 1 {
+			delete(l.perIP, ip)
+		} else {
+			l.perIP[ip]--
+		}
+	}
+}
+
+// 生成一个连接的消息频率限制，不限制时返回nil
+func (l *limiter) messageLimit() *tokenBucket {
+	if l == nil || l.config.MessageRate <= 0 {
+		return nil
+	}
+	return newTokenBucket(l.config.MessageRate, l.config.MessageBurst)
+}
+
+// 收到一个消息时调用，返回这个消息是否要处理、连接是否要断开
+func (l *limiter) allowMessage(channel IChannel, addr net.Addr, bucket *tokenBucket) (handle bool, disconnect bool) {
+	if bucket == nil {
+		return true, false
+	}
+	switch l.config.MessageAction {
+	case LimitDelay:
+		if wait := bucket.reserve(); wait > 0 {
+			l.report(LimitEvent{Kind: LimitMessageRate, Action: LimitDelay, Addr: addr, Channel: channel})
+			time.Sleep(wait)
+		}
+		return true, false
+	case LimitDisconnect:
+		if bucket.allow() {
+			return true, false
+		}
+		l.report(LimitEvent{Kind: LimitMessageRate, Action: LimitDisconnect, Addr: addr, Channel: channel})
+		return false, true
+	default:
+		if bucket.allow() {
+			return true, false
+		}
+		l.report(LimitEvent{Kind: LimitMessageRate, Action: LimitReject, Addr: addr, Channel: channel})
+		return false, false
+	}
+}
@@ -0,0 +1,106 @@
+package socket
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	bucket := newTokenBucket(10, 2)
+	if !bucket.allow() || !bucket.allow() || bucket.allow() {
+		t.Fatal("burst of 2 expected")
+	}
+	if wait := bucket.reserve(); wait <= 0 || wait > 100*time.Millisecond {
+		t.Fatalf("unexpected wait %v", wait)
+	}
+}
+
+func TestConnectionLimitPerIP(t *testing.T) {
+	addr := freeAddr(t)
+	events := make(chan LimitEvent, 4)
+	srvConfig := newTestConfig(addr)
+	srvConfig.Limits = &LimitConfig{MaxConnectionsPerIP: 1, Handler: func(event LimitEvent) { events <- event }}
+	server := startTestServer(t, srvConfig)
+	defer server.Stop()
+
+	first, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	select {
+	case event := <-events:
+		if event.Kind != LimitPerIP || event.Action != LimitReject {
+			t.Fatalf("unexpected event %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("per-IP limit not reported")
+	}
+	second.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := second.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("second connection should be closed, got %v", err)
+	}
+}
+
+func TestMessageRateDisconnect(t *testing.T) {
+	addr := freeAddr(t)
+	events := make(chan LimitEvent, 4)
+	errs := make(chan error, 1)
+	srvConfig := newTestConfig(addr)
+	srvConfig.ErrorHandler = func(channel IChannel, err error) { errs <- err }
+	srvConfig.Limits = &LimitConfig{MessageRate: 1, MessageAction: LimitDisconnect,
+		Handler: func(event LimitEvent) { events <- event }}
+	server := startTestServer(t, srvConfig)
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	frame, err := EncodeFrame(NewDefaultCodecFactory(), ProtoPack{Id: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		binary.Write(conn, binary.BigEndian, uint32(len(frame)))
+		conn.Write(frame)
+	}
+
+	select {
+	case event := <-events:
+		if event.Kind != LimitMessageRate || event.Channel == nil {
+			t.Fatalf("unexpected event %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message rate limit not reported")
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrRateLimited) || IsNormalClose(err) {
+			t.Fatalf("expected ErrRateLimited, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ErrorHandler not called for the rate limit disconnect")
+	}
+	// 还有没读的数据时关闭可能是RST
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+		t.Fatalf("connection should be closed, got %v", err)
+	}
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
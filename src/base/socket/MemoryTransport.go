@@ -0,0 +1,48 @@
+package socket
+
+import (
+	"bytes"
+)
+
+// 内存传输层，用于把ProtoPack 预先编码成数据帧
+type memoryTransport struct {
+	bytes.Buffer
+}
+
+func (transport *memoryTransport) Flush() error {
+	return nil
+}
+
+func (transport *memoryTransport) Close() error {
+	return nil
+}
+
+func (transport *memoryTransport) Open() error {
+	return nil
+}
+
+func (transport *memoryTransport) IsOpen() bool {
+	return true
+}
+
+func (transport *memoryTransport) Peek() bool {
+	return transport.Len() > 0
+}
+
+/**
+ * 用编码工厂把数据包编码成一个数据帧（不含FramedTransport 的长度头），
+ * 可以通过DefaultChannel.WriteFrame 写给多个连接。
+ * 编码时没有绑定连接，按连接设置的密钥不会生效，只会使用默认密钥，
+ * 设置了自己密钥的连接用WriteFrame 写入时会返回ErrFrameChannelKey
+ * @author abram
+ * @param factory 编码工厂
+ * @param protoPack 数据包
+ * @return 数据帧
+ */
+func EncodeFrame(factory ICodecFactory, protoPack ProtoPack) ([]byte, error) {
+	transport := &memoryTransport{}
+	if err := factory.GetCodec(transport).Encode(protoPack); err != nil {
+		return nil, err
+	}
+	return transport.Bytes(), nil
+}
@@ -0,0 +1,155 @@
+package socket
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+var (
+	ErrUnknownMessage  = errors.New("消息没有注册。")
+	ErrNotProtoMessage = errors.New("消息没有实现ProtoMessage。")
+)
+
+/**
+ * protobuf 消息接口，gogo/protobuf 等生成的消息类型都实现了这两个方法
+ * @author abram
+ */
+type ProtoMessage interface {
+	Marshal() ([]byte, error)
+	Unmarshal(data []byte) error
+}
+
+/**
+ * 消息注册表，保存ProtoPack.Id 和消息类型的对应关系，
+ * 消息体的格式由Serializer 决定，默认为protobuf
+ * @author abram
+ */
+type MessageRegistry struct {
+	lock  sync.RWMutex
+	types map[int16]reflect.Type // 消息id 对应的指针类型
+	ids   map[reflect.Type]int16
+}
+
+// 默认的消息注册表，Config.Messages 为nil 时使用
+var DefaultMessageRegistry = NewMessageRegistry()
+
+/**
+ * 生成一个消息注册表
+ * @author abram
+ * @return MessageRegistry
+ */
+func NewMessageRegistry() *MessageRegistry {
+	return &MessageRegistry{types: make(map[int16]reflect.Type), ids: make(map[reflect.Type]int16)}
+}
+
+/**
+ * 在默认的消息注册表中注册消息类型，一般在生成代码的init 中调用
+ * @author abram
+ * @param id 消息id
+ * @param msg 消息类型的指针，比如&pb.LoginReq{}
+ */
+func RegisterMessage(id int16, msg interface{}) {
+	DefaultMessageRegistry.Register(id, msg)
+}
+
+/**
+ * 注册消息类型
+ * @author abram
+ * @param id 消息id
+ * @param msg 消息类型的指针，比如&pb.LoginReq{}
+ */
+func (registry *MessageRegistry) Register(id int16, msg interface{}) {
+	if msg == nil {
+		panic("socket:MessageRegistry.Register msg is nil")
+	}
+	t := reflect.TypeOf(msg)
+	if t.Kind() != reflect.Ptr {
+		panic("socket:MessageRegistry.Register msg must be a pointer")
+	}
+	if id == HeartbeatPingId || id == HeartbeatPongId {
+		panic("socket:MessageRegistry.Register id " + strconv.Itoa(int(id)) + " is reserved")
+	}
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	if _, dup := registry.types[id]; dup {
+		panic("socket:MessageRegistry.Register called twice for id " + strconv.Itoa(int(id)))
+	}
+	if _, dup := registry.ids[t]; dup {
+		panic("socket:MessageRegistry.Register called twice for type " + t.String())
+	}
+	registry.types[id] = t
+	registry.ids[t] = id
+}
+
+// 消息类型对应的id
+func (registry *MessageRegistry) Id(msg interface{}) (int16, bool) {
+	return registry.typeId(reflect.TypeOf(msg))
+}
+
+func (registry *MessageRegistry) typeId(t reflect.Type) (int16, bool) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	id, ok := registry.ids[t]
+	return id, ok
+}
+
+// 生成id 对应类型的新消息
+func (registry *MessageRegistry) New(id int16) (interface{}, bool) {
+	registry.lock.RLock()
+	t, ok := registry.types[id]
+	registry.lock.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return reflect.New(t.Elem()).Interface(), true
+}
+
+// 用protobuf 把消息编码成数据包
+func (registry *MessageRegistry) Marshal(msg interface{}) (ProtoPack, error) {
+	return registry.MarshalWith(ProtobufSerializer, msg)
+}
+
+// 用protobuf 把数据包解码成消息
+func (registry *MessageRegistry) Unmarshal(protoPack *ProtoPack) (interface{}, error) {
+	return registry.UnmarshalWith(ProtobufSerializer, protoPack)
+}
+
+/**
+ * 把消息编码成数据包，填好Id 和Body
+ * @author abram
+ * @param serializer 序列化方式
+ * @param msg 已注册的消息
+ * @return ProtoPack
+ */
+func (registry *MessageRegistry) MarshalWith(serializer Serializer, msg interface{}) (ProtoPack, error) {
+	id, ok := registry.Id(msg)
+	if !ok {
+		return ProtoPack{}, fmt.Errorf("%w type=%T", ErrUnknownMessage, msg)
+	}
+	body, err := serializer.Marshal(msg)
+	if err != nil {
+		return ProtoPack{}, err
+	}
+	return ProtoPack{Id: id, Body: body}, nil
+}
+
+/**
+ * 把数据包的Body 解码成Id 对应的消息
+ * @author abram
+ * @param serializer 序列化方式
+ * @param protoPack 数据包
+ * @return 消息，类型为注册时的指针类型
+ */
+func (registry *MessageRegistry) UnmarshalWith(serializer Serializer, protoPack *ProtoPack) (interface{}, error) {
+	msg, ok := registry.New(protoPack.Id)
+	if !ok {
+		return nil, fmt.Errorf("%w id=%d", ErrUnknownMessage, protoPack.Id)
+	}
+	if err := serializer.Unmarshal(protoPack.Body, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
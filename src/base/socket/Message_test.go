@@ -0,0 +1,51 @@
+package socket
+
+import (
+	"errors"
+	"testing"
+)
+
+// 测试用的消息，模拟protobuf 生成的类型
+type testLoginReq struct {
+	Name string
+}
+
+func (msg *testLoginReq) Marshal() ([]byte, error) {
+	return []byte(msg.Name), nil
+}
+
+func (msg *testLoginReq) Unmarshal(data []byte) error {
+	msg.Name = string(data)
+	return nil
+}
+
+func TestMessageRegistry(t *testing.T) {
+	registry := NewMessageRegistry()
+	registry.Register(10, &testLoginReq{})
+
+	protoPack, err := registry.Marshal(&testLoginReq{Name: "abram"})
+	if err != nil || protoPack.Id != 10 || string(protoPack.Body) != "abram" {
+		t.Fatalf("unexpected pack %+v, %v", protoPack, err)
+	}
+	if _, err := registry.Unmarshal(&ProtoPack{Id: 11}); !errors.Is(err, ErrUnknownMessage) {
+		t.Fatalf("expected ErrUnknownMessage, got %v", err)
+	}
+
+	router := NewRouter()
+	router.SetMessageRegistry(registry)
+	var got string
+	router.HandleMessage(func(channel IChannel, msg *testLoginReq) {
+		got = msg.Name
+	})
+	router.Dispatch(nil, &protoPack)
+	if got != "abram" {
+		t.Fatalf("HandleMessage got %q", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("duplicate Register should panic")
+		}
+	}()
+	registry.Register(10, &testLoginReq{})
+}
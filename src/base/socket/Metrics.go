@@ -0,0 +1,300 @@
+package socket
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/**
+ * 监控接口，Server 和Client 在连接和消息的各个阶段调用，实现要能被多个协程同时调用。
+ * Metrics 是内置的实现
+ * @author abram
+ */
+type Instrumentation interface {
+	ConnectionOpened(channel IChannel)
+	ConnectionClosed(channel IChannel)
+	FrameRead(size int)    // 读到一个数据帧，size 包括4 字节的长度
+	FrameWritten(size int) // 写出一个数据帧，size 包括4 字节的长度
+	DecodeError(channel IChannel, err error)
+	MessageReceived(channel IChannel, protoPack *ProtoPack)
+	MessageHandled(channel IChannel, protoPack *ProtoPack, latency time.Duration)
+}
+
+// 包装消息处理函数，统计处理时间
+func instrumentHandler(instrumentation Instrumentation, handler func(channel IChannel, protoPack *ProtoPack)) func(channel IChannel, protoPack *ProtoPack) {
+	if instrumentation == nil {
+		return handler
+	}
+	return func(channel IChannel, protoPack *ProtoPack) {
+		start := time.Now()
+		defer func() {
+			instrumentation.MessageHandled(channel, protoPack, time.Since(start))
+		}()
+		handler(channel, protoPack)
+	}
+}
+
+// 在panicGuard 中调用监控接口，监控实现的panic 和处理函数一样报告
+type guardedInstrumentation struct {
+	instrumentation Instrumentation
+	guard           *panicGuard
+}
+
+func guardInstrumentation(instrumentation Instrumentation, guard *panicGuard) Instrumentation {
+	if instrumentation == nil {
+		return nil
+	}
+	return &guardedInstrumentation{instrumentation: instrumentation, guard: guard}
+}
+
+func (g *guardedInstrumentation) ConnectionOpened(channel IChannel) {
+	g.guard.run(channel, func() { g.instrumentation.ConnectionOpened(channel) })
+}
+
+func (g *guardedInstrumentation) ConnectionClosed(channel IChannel) {
+	g.guard.run(channel, func() { g.instrumentation.ConnectionClosed(channel) })
+}
+
+func (g *guardedInstrumentation) FrameRead(size int) {
+	g.guard.run(nil, func() { g.instrumentation.FrameRead(size) })
+}
+
+func (g *guardedInstrumentation) FrameWritten(size int) {
+	g.guard.run(nil, func() { g.instrumentation.FrameWritten(size) })
+}
+
+func (g *guardedInstrumentation) DecodeError(channel IChannel, err error) {
+	g.guard.run(channel, func() { g.instrumentation.DecodeError(channel, err) })
+}
+
+func (g *guardedInstrumentation) MessageReceived(channel IChannel, protoPack *ProtoPack) {
+	g.guard.run(channel, func() { g.instrumentation.MessageReceived(channel, protoPack) })
+}
+
+func (g *guardedInstrumentation) MessageHandled(channel IChannel, protoPack *ProtoPack, latency time.Duration) {
+	g.guard.run(channel, func() { g.instrumentation.MessageHandled(channel, protoPack, latency) })
+}
+
+// 是否是连接关闭或读超时产生的错误，这类错误不算解码错误
+func isClosedError(err error) bool {
+	err = classifyError("read", err)
+	return errors.Is(err, ErrConnectionClosed) || errors.Is(err, ErrPeerReset) || errors.Is(err, ErrTimeout)
+}
+
+// 默认的处理时间分桶，单位秒
+var DefaultLatencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// 默认最多按多少个消息id 分别统计，之后出现的id 合并到id="other"
+const DefaultMaxMessageIds = 256
+
+// 一个消息id 的统计
+type messageMetrics struct {
+	received uint64
+	lock     sync.Mutex
+	buckets  []uint64 // 每个桶的数量，不累加
+	count    uint64
+	sum      float64
+}
+
+/**
+ * 进程内的监控数据收集器，统计连接数、数据帧、流量、解码错误，
+ * 以及按ProtoPack.Id 统计的消息数和处理时间。ServeHTTP 按Prometheus 文本格式输出。
+ * ProtoPack.Id 由对方决定，分别统计的id 数量有上限，超过的合并到id="other"
+ * @author abram
+ */
+type Metrics struct {
+	connectionsActive int64
+	connectionsTotal  uint64
+	framesIn          uint64
+	framesOut         uint64
+	bytesIn           uint64
+	bytesOut          uint64
+	decodeErrors      uint64
+
+	bounds   []float64
+	lock     sync.RWMutex
+	messages map[int16]*messageMetrics
+	maxIds   int             // 最多分别统计的id 数量
+	other    *messageMetrics // 超过maxIds 之后出现的id
+}
+
+/**
+ * 生成监控数据收集器
+ * @author abram
+ * @param buckets 处理时间的分桶上限，单位秒，为空时使用DefaultLatencyBuckets
+ * @return Metrics
+ */
+func NewMetrics(buckets ...float64) *Metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	bounds := append([]float64(nil), buckets...)
+	sort.Float64s(bounds)
+	return &Metrics{
+		bounds:   bounds,
+		messages: make(map[int16]*messageMetrics),
+		maxIds:   DefaultMaxMessageIds,
+		other:    &messageMetrics{buckets: make([]uint64, len(bounds))},
+	}
+}
+
+// 设置最多分别统计的消息id 数量，只影响之后第一次出现的id
+func (metrics *Metrics) SetMaxMessageIds(max int) {
+	metrics.lock.Lock()
+	defer metrics.lock.Unlock()
+	metrics.maxIds = max
+}
+
+func (metrics *Metrics) ConnectionOpened(channel IChannel) {
+	atomic.AddInt64(&metrics.connectionsActive, 1)
+	atomic.AddUint64(&metrics.connectionsTotal, 1)
+}
+
+func (metrics *Metrics) ConnectionClosed(channel IChannel) {
+	atomic.AddInt64(&metrics.connectionsActive, -1)
+}
+
+func (metrics *Metrics) FrameRead(size int) {
+	atomic.AddUint64(&metrics.framesIn, 1)
+	atomic.AddUint64(&metrics.bytesIn, uint64(size))
+}
+
+func (metrics *Metrics) FrameWritten(size int) {
+	atomic.AddUint64(&metrics.framesOut, 1)
+	atomic.AddUint64(&metrics.bytesOut, uint64(size))
+}
+
+func (metrics *Metrics) DecodeError(channel IChannel, err error) {
+	atomic.AddUint64(&metrics.decodeErrors, 1)
+}
+
+func (metrics *Metrics) MessageReceived(channel IChannel, protoPack *ProtoPack) {
+	atomic.AddUint64(&metrics.message(protoPack.Id).received, 1)
+}
+
+func (metrics *Metrics) MessageHandled(channel IChannel, protoPack *ProtoPack, latency time.Duration) {
+	m := metrics.message(protoPack.Id)
+	seconds := latency.Seconds()
+	i := sort.SearchFloat64s(metrics.bounds, seconds)
+	m.lock.Lock()
+	if i < len(m.buckets) {
+		m.buckets[i]++
+	}
+	m.count++
+	m.sum += seconds
+	m.lock.Unlock()
+}
+
+// 消息id 的统计，第一次用到时生成，id 数量达到上限后返回other
+func (metrics *Metrics) message(id int16) *messageMetrics {
+	metrics.lock.RLock()
+	m, ok := metrics.messages[id]
+	metrics.lock.RUnlock()
+	if ok {
+		return m
+	}
+	metrics.lock.Lock()
+	defer metrics.lock.Unlock()
+	if m, ok = metrics.messages[id]; !ok {
+		if len(metrics.messages) >= metrics.maxIds {
+			return metrics.other
+		}
+		m = &messageMetrics{buckets: make([]uint64, len(metrics.bounds))}
+		metrics.messages[id] = m
+	}
+	return m
+}
+
+// 当前的连接数
+func (metrics *Metrics) ActiveConnections() int64 {
+	return atomic.LoadInt64(&metrics.connectionsActive)
+}
+
+/**
+ * 按Prometheus 文本格式输出所有监控数据
+ * @author abram
+ * @param w 输出
+ */
+func (metrics *Metrics) WritePrometheus(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	writeMetric(out, "socket_connections_active", "gauge", "Number of open connections.", atomic.LoadInt64(&metrics.connectionsActive))
+	writeMetric(out, "socket_connections_total", "counter", "Number of accepted connections.", atomic.LoadUint64(&metrics.connectionsTotal))
+	writeMetric(out, "socket_frames_received_total", "counter", "Number of frames read.", atomic.LoadUint64(&metrics.framesIn))
+	writeMetric(out, "socket_frames_sent_total", "counter", "Number of frames written.", atomic.LoadUint64(&metrics.framesOut))
+	writeMetric(out, "socket_received_bytes_total", "counter", "Bytes read, including frame headers.", atomic.LoadUint64(&metrics.bytesIn))
+	writeMetric(out, "socket_sent_bytes_total", "counter", "Bytes written, including frame headers.", atomic.LoadUint64(&metrics.bytesOut))
+	writeMetric(out, "socket_decode_errors_total", "counter", "Number of frames that could not be decoded.", atomic.LoadUint64(&metrics.decodeErrors))
+
+	metrics.lock.RLock()
+	ids := make([]int, 0, len(metrics.messages))
+	for id := range metrics.messages {
+		ids = append(ids, int(id))
+	}
+	metrics.lock.RUnlock()
+	sort.Ints(ids)
+	labels := make([]string, 0, len(ids)+1)
+	series := make([]*messageMetrics, 0, len(ids)+1)
+	for _, id := range ids {
+		labels = append(labels, strconv.Itoa(id))
+		series = append(series, metrics.message(int16(id)))
+	}
+	if atomic.LoadUint64(&metrics.other.received) > 0 || metrics.other.handled() > 0 {
+		labels = append(labels, "other")
+		series = append(series, metrics.other)
+	}
+
+	fmt.Fprintln(out, "# HELP socket_messages_received_total Number of messages received by id.")
+	fmt.Fprintln(out, "# TYPE socket_messages_received_total counter")
+	for i, m := range series {
+		fmt.Fprintf(out, "socket_messages_received_total{id=\"%s\"} %d\n", labels[i], atomic.LoadUint64(&m.received))
+	}
+
+	fmt.Fprintln(out, "# HELP socket_message_handle_seconds Time spent in the message handler by id.")
+	fmt.Fprintln(out, "# TYPE socket_message_handle_seconds histogram")
+	for i, m := range series {
+		id := labels[i]
+		m.lock.Lock()
+		buckets := append([]uint64(nil), m.buckets...)
+		count, sum := m.count, m.sum
+		m.lock.Unlock()
+
+		var cumulative uint64
+		for i, bound := range metrics.bounds {
+			cumulative += buckets[i]
+			fmt.Fprintf(out, "socket_message_handle_seconds_bucket{id=\"%s\",le=\"%s\"} %d\n", id, formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(out, "socket_message_handle_seconds_bucket{id=\"%s\",le=\"+Inf\"} %d\n", id, count)
+		fmt.Fprintf(out, "socket_message_handle_seconds_sum{id=\"%s\"} %s\n", id, formatFloat(sum))
+		fmt.Fprintf(out, "socket_message_handle_seconds_count{id=\"%s\"} %d\n", id, count)
+	}
+	return out.Flush()
+}
+
+// 记录过的处理次数
+func (m *messageMetrics) handled() uint64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.count
+}
+
+func writeMetric(out io.Writer, name, typ, help string, value interface{}) {
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, typ, name, value)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// 输出Prometheus 文本格式，可以挂到/metrics 上
+func (metrics *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.WritePrometheus(w)
+}
@@ -0,0 +1,135 @@
+package socket
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsPrometheus(t *testing.T) {
+	metrics := NewMetrics(0.1, 0.01)
+	metrics.ConnectionOpened(nil)
+	metrics.ConnectionOpened(nil)
+	metrics.ConnectionClosed(nil)
+	metrics.FrameRead(20)
+	metrics.FrameWritten(9)
+	metrics.DecodeError(nil, ErrBodyTooLarge)
+	protoPack := &ProtoPack{Id: 3}
+	metrics.MessageReceived(nil, protoPack)
+	metrics.MessageHandled(nil, protoPack, 5*time.Millisecond)
+	metrics.MessageHandled(nil, protoPack, 50*time.Millisecond)
+	metrics.MessageHandled(nil, protoPack, time.Second)
+	// 超过上限的id 合并统计
+	metrics.SetMaxMessageIds(2)
+	for _, id := range []int16{4, 5, 6} {
+		metrics.MessageReceived(nil, &ProtoPack{Id: id})
+	}
+	metrics.MessageHandled(nil, &ProtoPack{Id: 6}, time.Millisecond)
+
+	var out bytes.Buffer
+	if err := metrics.WritePrometheus(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"socket_connections_active 1",
+		"socket_connections_total 2",
+		"socket_received_bytes_total 20",
+		"socket_sent_bytes_total 9",
+		"socket_decode_errors_total 1",
+		"# TYPE socket_message_handle_seconds histogram",
+		`socket_messages_received_total{id="3"} 1`,
+		`socket_message_handle_seconds_bucket{id="3",le="0.01"} 1`,
+		`socket_message_handle_seconds_bucket{id="3",le="0.1"} 2`,
+		`socket_message_handle_seconds_bucket{id="3",le="+Inf"} 3`,
+		`socket_message_handle_seconds_count{id="3"} 3`,
+		`socket_messages_received_total{id="4"} 1`,
+		`socket_messages_received_total{id="other"} 2`,
+		`socket_message_handle_seconds_count{id="other"} 1`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, out.String())
+		}
+	}
+	if strings.Contains(out.String(), `id="5"`) {
+		t.Errorf("ids over the limit should not get their own series:\n%s", out.String())
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	addr := freeAddr(t)
+	metrics := NewMetrics()
+	handled := make(chan struct{}, 1)
+	srvConfig := newTestConfig(addr)
+	srvConfig.MessageHandler = func(channel IChannel, protoPack *ProtoPack) {
+		channel.Write(ProtoPack{Id: protoPack.Id, Body: protoPack.Body})
+		handled <- struct{}{}
+	}
+	srvConfig.Instrumentation = metrics
+	server := startTestServer(t, srvConfig)
+	defer server.Stop()
+
+	cliConfig := newTestConfig(addr)
+	cliMetrics := NewMetrics()
+	replied := make(chan struct{}, 1)
+	cliConfig.MessageHandler = func(channel IChannel, protoPack *ProtoPack) { replied <- struct{}{} }
+	cliConfig.Instrumentation = cliMetrics
+	client, err := NewClient(cliConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Write(ProtoPack{Id: 42, Body: []byte("ping")}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Fatal("message not handled")
+	}
+	select {
+	case <-replied:
+	case <-time.After(time.Second):
+		t.Fatal("reply not handled")
+	}
+	// 处理时间在处理函数返回后才记录
+	time.Sleep(20 * time.Millisecond)
+
+	if n := metrics.ActiveConnections(); n != 1 {
+		t.Fatalf("ActiveConnections() = %d, want 1", n)
+	}
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+	for _, line := range []string{
+		"socket_frames_received_total 1",
+		"socket_frames_sent_total 1",
+		`socket_messages_received_total{id="42"} 1`,
+		`socket_message_handle_seconds_count{id="42"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, body)
+		}
+	}
+
+	// 客户端的监控
+	var out bytes.Buffer
+	cliMetrics.WritePrometheus(&out)
+	for _, line := range []string{
+		"socket_connections_active 1",
+		"socket_frames_sent_total 1",
+		"socket_frames_received_total 1",
+		`socket_message_handle_seconds_count{id="42"} 1`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("missing %q in client metrics:\n%s", line, out.String())
+		}
+	}
+	if ct := recorder.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
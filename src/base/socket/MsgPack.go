@@ -0,0 +1,646 @@
+package socket
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+var (
+	ErrMsgPackCorrupt = errors.New("MessagePack 数据格式错误。")
+	ErrMsgPackTooDeep = errors.New("MessagePack 数据嵌套太深。")
+)
+
+// 数组和map 最多嵌套的层数，防止恶意数据让递归解码栈溢出
+const msgPackMaxDepth = 100
+
+/**
+ * MessagePack 序列化，支持基本类型、字符串、[]byte、切片、数组、map、结构体和指针。
+ * 结构体按map 编码，字段名取`msgpack:"name"` 标签，没有时取`json` 标签，再没有时取字段名，
+ * 标签为"-" 的字段跳过
+ * @author abram
+ */
+type MsgPackSerializer struct{}
+
+func (serializer MsgPackSerializer) Marshal(v interface{}) ([]byte, error) {
+	encoder := &msgPackEncoder{}
+	if err := encoder.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return encoder.buf, nil
+}
+
+func (serializer MsgPackSerializer) Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("socket: msgpack Unmarshal needs a non-nil pointer, got %T", v)
+	}
+	decoder := &msgPackDecoder{data: data}
+	value, err := decoder.decode()
+	if err != nil {
+		return err
+	}
+	if decoder.pos != len(data) {
+		return ErrMsgPackCorrupt
+	}
+	return msgPackAssign(value, rv.Elem())
+}
+
+// 结构体字段在MessagePack 中的名字，返回空串表示跳过
+func msgPackFieldName(field reflect.StructField) string {
+	if field.PkgPath != "" {
+		return ""
+	}
+	tag, ok := field.Tag.Lookup("msgpack")
+	if !ok {
+		tag = field.Tag.Get("json")
+	}
+	if tag == "-" {
+		return ""
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name
+	}
+	return field.Name
+}
+
+type msgPackEncoder struct {
+	buf []byte
+}
+
+func (encoder *msgPackEncoder) write(b ...byte) {
+	encoder.buf = append(encoder.buf, b...)
+}
+
+func (encoder *msgPackEncoder) writeUint(prefix byte, v uint64, size int) {
+	encoder.buf = append(encoder.buf, prefix)
+	for i := size - 1; i >= 0; i-- {
+		encoder.buf = append(encoder.buf, byte(v>>(uint(i)*8)))
+	}
+}
+
+func (encoder *msgPackEncoder) encodeInt(v int64) {
+	switch {
+	case v >= 0:
+		encoder.encodeUint(uint64(v))
+	case v >= -32:
+		encoder.write(byte(v))
+	case v >= math.MinInt8:
+		encoder.writeUint(0xd0, uint64(v), 1)
+	case v >= math.MinInt16:
+		encoder.writeUint(0xd1, uint64(v), 2)
+	case v >= math.MinInt32:
+		encoder.writeUint(0xd2, uint64(v), 4)
+	default:
+		encoder.writeUint(0xd3, uint64(v), 8)
+	}
+}
+
+func (encoder *msgPackEncoder) encodeUint(v uint64) {
+	switch {
+	case v <= 0x7f:
+		encoder.write(byte(v))
+	case v <= math.MaxUint8:
+		encoder.writeUint(0xcc, v, 1)
+	case v <= math.MaxUint16:
+		encoder.writeUint(0xcd, v, 2)
+	case v <= math.MaxUint32:
+		encoder.writeUint(0xce, v, 4)
+	default:
+		encoder.writeUint(0xcf, v, 8)
+	}
+}
+
+func (encoder *msgPackEncoder) encodeString(s string) {
+	n := uint64(len(s))
+	switch {
+	case n <= 31:
+		encoder.write(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		encoder.writeUint(0xd9, n, 1)
+	case n <= math.MaxUint16:
+		encoder.writeUint(0xda, n, 2)
+	default:
+		encoder.writeUint(0xdb, n, 4)
+	}
+	encoder.buf = append(encoder.buf, s...)
+}
+
+func (encoder *msgPackEncoder) encodeBinary(b []byte) {
+	n := uint64(len(b))
+	switch {
+	case n <= math.MaxUint8:
+		encoder.writeUint(0xc4, n, 1)
+	case n <= math.MaxUint16:
+		encoder.writeUint(0xc5, n, 2)
+	default:
+		encoder.writeUint(0xc6, n, 4)
+	}
+	encoder.buf = append(encoder.buf, b...)
+}
+
+func (encoder *msgPackEncoder) encodeArrayHeader(n int) {
+	switch {
+	case n <= 15:
+		encoder.write(0x90 | byte(n))
+	case n <= math.MaxUint16:
+		encoder.writeUint(0xdc, uint64(n), 2)
+	default:
+		encoder.writeUint(0xdd, uint64(n), 4)
+	}
+}
+
+func (encoder *msgPackEncoder) encodeMapHeader(n int) {
+	switch {
+	case n <= 15:
+		encoder.write(0x80 | byte(n))
+	case n <= math.MaxUint16:
+		encoder.writeUint(0xde, uint64(n), 2)
+	default:
+		encoder.writeUint(0xdf, uint64(n), 4)
+	}
+}
+
+func (encoder *msgPackEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		encoder.write(0xc0)
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			encoder.write(0xc0)
+			return nil
+		}
+		return encoder.encode(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			encoder.write(0xc3)
+		} else {
+			encoder.write(0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		encoder.encodeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		encoder.encodeUint(v.Uint())
+	case reflect.Float32:
+		encoder.writeUint(0xca, uint64(math.Float32bits(float32(v.Float()))), 4)
+	case reflect.Float64:
+		encoder.writeUint(0xcb, math.Float64bits(v.Float()), 8)
+	case reflect.String:
+		encoder.encodeString(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			encoder.write(0xc0)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			encoder.encodeBinary(v.Bytes())
+			return nil
+		}
+		fallthrough
+	case reflect.Array:
+		encoder.encodeArrayHeader(v.Len())
+		for i := 0; i < v.Len(); i++ {
+			if err := encoder.encode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			encoder.write(0xc0)
+			return nil
+		}
+		encoder.encodeMapHeader(v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := encoder.encode(iter.Key()); err != nil {
+				return err
+			}
+			if err := encoder.encode(iter.Value()); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		t := v.Type()
+		var fields []int
+		for i := 0; i < t.NumField(); i++ {
+			if msgPackFieldName(t.Field(i)) != "" {
+				fields = append(fields, i)
+			}
+		}
+		encoder.encodeMapHeader(len(fields))
+		for _, i := range fields {
+			encoder.encodeString(msgPackFieldName(t.Field(i)))
+			if err := encoder.encode(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("socket: msgpack unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// 先解码成通用的值：nil、bool、int64、uint64、float64、string、[]byte、[]interface{}、msgPackMap
+type msgPackDecoder struct {
+	data  []byte
+	pos   int
+	depth int // 当前嵌套的层数
+}
+
+// 进入一层数组或map
+func (decoder *msgPackDecoder) enter() error {
+	decoder.depth++
+	if decoder.depth > msgPackMaxDepth {
+		return ErrMsgPackTooDeep
+	}
+	return nil
+}
+
+// 解码后的map，保留键的顺序，键可能是任意类型
+type msgPackMap struct {
+	keys   []interface{}
+	values []interface{}
+}
+
+func (decoder *msgPackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || len(decoder.data)-decoder.pos < n {
+		return nil, ErrMsgPackCorrupt
+	}
+	b := decoder.data[decoder.pos : decoder.pos+n]
+	decoder.pos += n
+	return b, nil
+}
+
+func (decoder *msgPackDecoder) readUint(size int) (uint64, error) {
+	b, err := decoder.next(size)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+func (decoder *msgPackDecoder) readBytes(size int) ([]byte, error) {
+	n, err := decoder.readUint(size)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(decoder.data)-decoder.pos) {
+		return nil, ErrMsgPackCorrupt
+	}
+	return decoder.next(int(n))
+}
+
+func (decoder *msgPackDecoder) decodeArray(n uint64) (interface{}, error) {
+	// 每个元素至少一个字节，防止伪造的长度导致分配过多内存
+	if n > uint64(len(decoder.data)-decoder.pos) {
+		return nil, ErrMsgPackCorrupt
+	}
+	if err := decoder.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { decoder.depth-- }()
+	values := make([]interface{}, n)
+	for i := range values {
+		v, err := decoder.decode()
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+func (decoder *msgPackDecoder) decodeMap(n uint64) (interface{}, error) {
+	if n > uint64(len(decoder.data)-decoder.pos)/2 {
+		return nil, ErrMsgPackCorrupt
+	}
+	if err := decoder.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { decoder.depth-- }()
+	m := &msgPackMap{keys: make([]interface{}, n), values: make([]interface{}, n)}
+	for i := uint64(0); i < n; i++ {
+		k, err := decoder.decode()
+		if err != nil {
+			return nil, err
+		}
+		v, err := decoder.decode()
+		if err != nil {
+			return nil, err
+		}
+		m.keys[i], m.values[i] = k, v
+	}
+	return m, nil
+}
+
+func (decoder *msgPackDecoder) decode() (interface{}, error) {
+	b, err := decoder.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return decoder.decodeMap(uint64(c & 0x0f))
+	case c&0xf0 == 0x90:
+		return decoder.decodeArray(uint64(c & 0x0f))
+	case c&0xe0 == 0xa0:
+		s, err := decoder.next(int(c & 0x1f))
+		return string(s), err
+	}
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		bin, err := decoder.readBytes(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), bin...), nil
+	case 0xca:
+		v, err := decoder.readUint(4)
+		return float64(math.Float32frombits(uint32(v))), err
+	case 0xcb:
+		v, err := decoder.readUint(8)
+		return math.Float64frombits(v), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return decoder.readUint(1 << (c - 0xcc))
+	case 0xd0:
+		v, err := decoder.readUint(1)
+		return int64(int8(v)), err
+	case 0xd1:
+		v, err := decoder.readUint(2)
+		return int64(int16(v)), err
+	case 0xd2:
+		v, err := decoder.readUint(4)
+		return int64(int32(v)), err
+	case 0xd3:
+		v, err := decoder.readUint(8)
+		return int64(v), err
+	case 0xd9, 0xda, 0xdb:
+		s, err := decoder.readBytes(1 << (c - 0xd9))
+		return string(s), err
+	case 0xdc, 0xdd:
+		n, err := decoder.readUint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.decodeArray(n)
+	case 0xde, 0xdf:
+		n, err := decoder.readUint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.decodeMap(n)
+	}
+	// ext 类型不支持
+	return nil, fmt.Errorf("%w: unsupported format 0x%02x", ErrMsgPackCorrupt, c)
+}
+
+// 把msgPackMap 转成Go 的map，键都是字符串时为map[string]interface{}
+func (m *msgPackMap) generic() (interface{}, error) {
+	allString := true
+	for _, k := range m.keys {
+		if _, ok := k.(string); !ok {
+			allString = false
+			break
+		}
+	}
+	if allString {
+		result := make(map[string]interface{}, len(m.keys))
+		for i, k := range m.keys {
+			v, err := msgPackGeneric(m.values[i])
+			if err != nil {
+				return nil, err
+			}
+			result[k.(string)] = v
+		}
+		return result, nil
+	}
+	result := make(map[interface{}]interface{}, len(m.keys))
+	for i, k := range m.keys {
+		key, err := msgPackGeneric(k)
+		if err != nil {
+			return nil, err
+		}
+		if key != nil && !reflect.TypeOf(key).Comparable() {
+			return nil, fmt.Errorf("%w: unhashable map key", ErrMsgPackCorrupt)
+		}
+		v, err := msgPackGeneric(m.values[i])
+		if err != nil {
+			return nil, err
+		}
+		result[key] = v
+	}
+	return result, nil
+}
+
+// 解码到interface{} 时使用的值
+func msgPackGeneric(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case *msgPackMap:
+		return value.generic()
+	case []interface{}:
+		for i, v := range value {
+			g, err := msgPackGeneric(v)
+			if err != nil {
+				return nil, err
+			}
+			value[i] = g
+		}
+	}
+	return value, nil
+}
+
+func msgPackTypeError(value interface{}, target reflect.Value) error {
+	return fmt.Errorf("socket: msgpack cannot unmarshal %T into %s", value, target.Type())
+}
+
+// 把通用的值赋给target
+func msgPackAssign(value interface{}, target reflect.Value) error {
+	if value == nil {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+	switch target.Kind() {
+	case reflect.Ptr:
+		if target.IsNil() {
+			target.Set(reflect.New(target.Type().Elem()))
+		}
+		return msgPackAssign(value, target.Elem())
+	case reflect.Interface:
+		if target.NumMethod() != 0 {
+			return msgPackTypeError(value, target)
+		}
+		g, err := msgPackGeneric(value)
+		if err != nil {
+			return err
+		}
+		target.Set(reflect.ValueOf(g))
+	case reflect.Bool:
+		v, ok := value.(bool)
+		if !ok {
+			return msgPackTypeError(value, target)
+		}
+		target.SetBool(v)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var v int64
+		switch value := value.(type) {
+		case int64:
+			v = value
+		case uint64:
+			if value > math.MaxInt64 {
+				return msgPackTypeError(value, target)
+			}
+			v = int64(value)
+		default:
+			return msgPackTypeError(value, target)
+		}
+		if target.OverflowInt(v) {
+			return msgPackTypeError(value, target)
+		}
+		target.SetInt(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var v uint64
+		switch value := value.(type) {
+		case int64:
+			if value < 0 {
+				return msgPackTypeError(value, target)
+			}
+			v = uint64(value)
+		case uint64:
+			v = value
+		default:
+			return msgPackTypeError(value, target)
+		}
+		if target.OverflowUint(v) {
+			return msgPackTypeError(value, target)
+		}
+		target.SetUint(v)
+	case reflect.Float32, reflect.Float64:
+		switch value := value.(type) {
+		case float64:
+			target.SetFloat(value)
+		case int64:
+			target.SetFloat(float64(value))
+		case uint64:
+			target.SetFloat(float64(value))
+		default:
+			return msgPackTypeError(value, target)
+		}
+	case reflect.String:
+		switch value := value.(type) {
+		case string:
+			target.SetString(value)
+		case []byte:
+			target.SetString(string(value))
+		default:
+			return msgPackTypeError(value, target)
+		}
+	case reflect.Slice:
+		if target.Type().Elem().Kind() == reflect.Uint8 {
+			switch value := value.(type) {
+			case []byte:
+				target.SetBytes(value)
+				return nil
+			case string:
+				target.SetBytes([]byte(value))
+				return nil
+			}
+		}
+		values, ok := value.([]interface{})
+		if !ok {
+			return msgPackTypeError(value, target)
+		}
+		slice := reflect.MakeSlice(target.Type(), len(values), len(values))
+		for i, v := range values {
+			if err := msgPackAssign(v, slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		target.Set(slice)
+	case reflect.Array:
+		values, ok := value.([]interface{})
+		if !ok {
+			if bin, isBin := value.([]byte); isBin && target.Type().Elem().Kind() == reflect.Uint8 {
+				values = make([]interface{}, len(bin))
+				for i, b := range bin {
+					values[i] = int64(b)
+				}
+			} else {
+				return msgPackTypeError(value, target)
+			}
+		}
+		if len(values) != target.Len() {
+			return msgPackTypeError(value, target)
+		}
+		for i, v := range values {
+			if err := msgPackAssign(v, target.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		m, ok := value.(*msgPackMap)
+		if !ok {
+			return msgPackTypeError(value, target)
+		}
+		t := target.Type()
+		result := reflect.MakeMapWithSize(t, len(m.keys))
+		for i := range m.keys {
+			key := reflect.New(t.Key()).Elem()
+			if err := msgPackAssign(m.keys[i], key); err != nil {
+				return err
+			}
+			// 键为interface{} 时，数组之类的值不能作为键
+			if key.Kind() == reflect.Interface && !key.IsNil() && !key.Elem().Type().Comparable() {
+				return fmt.Errorf("%w: unhashable map key", ErrMsgPackCorrupt)
+			}
+			elem := reflect.New(t.Elem()).Elem()
+			if err := msgPackAssign(m.values[i], elem); err != nil {
+				return err
+			}
+			result.SetMapIndex(key, elem)
+		}
+		target.Set(result)
+	case reflect.Struct:
+		m, ok := value.(*msgPackMap)
+		if !ok {
+			return msgPackTypeError(value, target)
+		}
+		t := target.Type()
+		for i := range m.keys {
+			name, ok := m.keys[i].(string)
+			if !ok {
+				continue
+			}
+			// 不认识的字段忽略，方便双方独立升级
+			for j := 0; j < t.NumField(); j++ {
+				if msgPackFieldName(t.Field(j)) == name {
+					if err := msgPackAssign(m.values[i], target.Field(j)); err != nil {
+						return err
+					}
+					break
+				}
+			}
+		}
+	default:
+		return msgPackTypeError(value, target)
+	}
+	return nil
+}
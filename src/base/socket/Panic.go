@@ -0,0 +1,80 @@
+package socket
+
+import (
+	"log"
+	"runtime/debug"
+)
+
+// 处理函数panic 之后的处理方式
+type PanicPolicy int
+
+const (
+	PanicContinue PanicPolicy = iota // 只报告，连接继续处理后面的消息
+	PanicClose                       // 报告后关闭出错的连接，不影响其它连接
+)
+
+/**
+ * 处理函数panic 时调用
+ * @author abram
+ * @param channel 出错的连接
+ * @param protoPack 正在处理的数据包，ConnectedHandler 和DisconnectHandler 中panic 时为nil
+ * @param err recover 得到的值
+ * @param stack panic 时的调用栈
+ */
+type PanicHandler func(channel IChannel, protoPack *ProtoPack, err interface{}, stack []byte)
+
+// 在处理函数外面recover，panic 不会让整个进程退出
+type panicGuard struct {
+	handler PanicHandler
+	policy  PanicPolicy
+}
+
+func newPanicGuard(handler PanicHandler, policy PanicPolicy) *panicGuard {
+	return &panicGuard{handler: handler, policy: policy}
+}
+
+// 要直接用defer 调用
+func (guard *panicGuard) handlePanic(channel IChannel, protoPack *ProtoPack) {
+	err := recover()
+	if err == nil {
+		return
+	}
+	guard.report(channel, protoPack, err, debug.Stack())
+	if guard.policy == PanicClose && channel != nil {
+		channel.Close()
+	}
+}
+
+// 报告panic，PanicHandler 自己panic 时只记录日志
+func (guard *panicGuard) report(channel IChannel, protoPack *ProtoPack, err interface{}, stack []byte) {
+	defer func() {
+		if e := recover(); e != nil {
+			log.Printf("PanicHandler panic: %v\n%s", e, debug.Stack())
+		}
+	}()
+	if guard.handler != nil {
+		guard.handler(channel, protoPack, err, stack)
+	} else {
+		log.Printf("处理函数panic: %v\n%s", err, stack)
+	}
+}
+
+// 包装消息处理函数
+func (guard *panicGuard) wrap(handler func(channel IChannel, protoPack *ProtoPack)) func(channel IChannel, protoPack *ProtoPack) {
+	return func(channel IChannel, protoPack *ProtoPack) {
+		defer guard.handlePanic(channel, protoPack)
+		handler(channel, protoPack)
+	}
+}
+
+// 调用连接事件处理函数
+func (guard *panicGuard) call(channel IChannel, handler func(channel IChannel)) {
+	defer guard.handlePanic(channel, nil)
+	handler(channel)
+}
+
+// 调用其它回调，比如ErrorHandler、LimitConfig.Handler 和监控接口，channel 可以为nil
+func (guard *panicGuard) run(channel IChannel, fn func()) {
+	defer guard.handlePanic(channel, nil)
+	fn()
+}
@@ -0,0 +1,163 @@
+package socket
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type panicReport struct {
+	protoPack *ProtoPack
+	err       interface{}
+	stack     string
+}
+
+// 启动一个会panic 的服务，返回panic 报告、处理完的消息和连接的客户端
+func startPanicServer(t *testing.T, policy PanicPolicy) (*Server, chan panicReport, chan int16, *Client, chan struct{}) {
+	addr := freeAddr(t)
+	reports := make(chan panicReport, 4)
+	handled := make(chan int16, 4)
+	srvConfig := newTestConfig(addr)
+	srvConfig.ConnectedHandler = func(channel IChannel) { panic("connected") }
+	srvConfig.MessageHandler = func(channel IChannel, protoPack *ProtoPack) {
+		if protoPack.Id == 1 {
+			panic("bad message")
+		}
+		handled <- protoPack.Id
+	}
+	srvConfig.Dispatch = &DispatchConfig{Mode: DispatchOrdered}
+	srvConfig.PanicHandler = func(channel IChannel, protoPack *ProtoPack, err interface{}, stack []byte) {
+		reports <- panicReport{protoPack, err, string(stack)}
+	}
+	srvConfig.PanicPolicy = policy
+	server := startTestServer(t, srvConfig)
+
+	disconnected := make(chan struct{}, 1)
+	cliConfig := newTestConfig(addr)
+	cliConfig.DisconnectHandler = func(channel IChannel) { disconnected <- struct{}{} }
+	client, err := NewClient(cliConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Open(); err != nil {
+		t.Fatal(err)
+	}
+	return server, reports, handled, client, disconnected
+}
+
+func waitReport(t *testing.T, reports chan panicReport) panicReport {
+	select {
+	case report := <-reports:
+		return report
+	case <-time.After(time.Second):
+		t.Fatal("panic not reported")
+	}
+	return panicReport{}
+}
+
+func TestPanicContinue(t *testing.T) {
+	server, reports, handled, client, _ := startPanicServer(t, PanicContinue)
+	defer server.Stop()
+	defer client.Close()
+
+	report := waitReport(t, reports)
+	if report.protoPack != nil || report.err != "connected" {
+		t.Fatalf("unexpected report for ConnectedHandler: %+v", report)
+	}
+
+	if err := client.Write(ProtoPack{Id: 1}); err != nil {
+		t.Fatal(err)
+	}
+	report = waitReport(t, reports)
+	if report.protoPack == nil || report.protoPack.Id != 1 || report.err != "bad message" {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if !strings.Contains(report.stack, "Panic_test.go") {
+		t.Fatalf("stack does not point at the handler:\n%s", report.stack)
+	}
+
+	// 同一个连接继续处理后面的消息
+	client.Write(ProtoPack{Id: 2})
+	select {
+	case id := <-handled:
+		if id != 2 {
+			t.Fatalf("handled id %d", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("connection stopped after panic")
+	}
+}
+
+func TestPanicClose(t *testing.T) {
+	server, reports, _, client, disconnected := startPanicServer(t, PanicClose)
+	defer server.Stop()
+	defer client.Close()
+
+	// ConnectedHandler 的panic 也会关闭连接
+	waitReport(t, reports)
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Fatal("connection not closed after panic")
+	}
+}
+
+// ConnectionOpened 会panic 的监控
+type panicInstrumentation struct {
+	*Metrics
+}
+
+func (p panicInstrumentation) ConnectionOpened(channel IChannel) {
+	panic("instrument")
+}
+
+func TestPanicInCallbacks(t *testing.T) {
+	addr := freeAddr(t)
+	reports := make(chan interface{}, 8)
+	connected := make(chan struct{}, 2)
+	srvConfig := newTestConfig(addr)
+	srvConfig.ConnectedHandler = func(channel IChannel) { connected <- struct{}{} }
+	srvConfig.MaxFrameSize = 64
+	srvConfig.OversizeHandler = func(channel IChannel, err error) { panic("oversize") }
+	srvConfig.ErrorHandler = func(channel IChannel, err error) { panic("error") }
+	srvConfig.Instrumentation = panicInstrumentation{NewMetrics()}
+	srvConfig.PanicHandler = func(channel IChannel, protoPack *ProtoPack, err interface{}, stack []byte) {
+		reports <- err
+		if err == "error" {
+			panic("panic handler")
+		}
+	}
+	server := startTestServer(t, srvConfig)
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte{0, 0, 1, 0})
+	defer conn.Close()
+	for _, want := range []string{"instrument", "oversize", "error"} {
+		select {
+		case got := <-reports:
+			if got != want {
+				t.Fatalf("expected panic %q, got %v", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("panic %q not reported", want)
+		}
+	}
+
+	// 服务还在正常工作
+	<-connected
+	conn2, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	select {
+	case <-connected:
+	case <-time.After(time.Second):
+		t.Fatal("server stopped accepting after callback panics")
+	}
+}
@@ -0,0 +1,440 @@
+package socket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrProxyHeaderInvalid = errors.New("PROXY 协议头格式错误。")
+	ErrProxyHeaderMissing = errors.New("可信的上游没有发送PROXY 协议头。")
+)
+
+const (
+	DefaultProxyHeaderTimeout = 5 * time.Second // 读取协议头的默认超时时间，也是Timeout 为0 时使用的值
+	DefaultProxyMaxPending    = 256             // 同时读取协议头的连接数的默认上限
+
+	proxyV1Prefix    = "PROXY "
+	proxyV1MaxLength = 107 // 规范中v1 头的最大长度，包括\r\n
+	proxyV2HeaderLen = 16
+)
+
+// v2 头的12 字节签名
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+/**
+ * PROXY 协议配置。负载均衡在TCP 连接开始时发送PROXY 协议头(v1 或v2)，
+ * 里面是真实的客户端地址。只有来自Trusted 网段的连接才会解析协议头，
+ * 其它连接按普通连接处理，防止客户端伪造地址。
+ * Required 为false 时，可信的连接在Timeout 内没有发来任何数据按没有协议头处理，
+ * 所以服务端先发数据的协议要等Timeout 之后才开始
+ * @author abram
+ */
+type ProxyProtocolConfig struct {
+	Trusted    []string      // 可信的上游网段，比如10.0.0.0/8
+	TrustUnix  bool          // unix 套接字的连接是否可信，可信时本机能连接这个套接字的进程都可以伪造地址
+	Required   bool          // 可信的上游必须发送协议头，否则关闭连接
+	Timeout    time.Duration // 读取协议头的超时时间，0 时取DefaultProxyHeaderTimeout，不会无限等待
+	MaxPending int           // 同时读取协议头的连接数上限，达到后暂停接受连接，0 时取DefaultProxyMaxPending
+}
+
+/**
+ * 生成默认的PROXY 协议配置，还需要设置Trusted
+ * @author abram
+ * @return ProxyProtocolConfig
+ */
+func NewProxyProtocolConfig() *ProxyProtocolConfig {
+	return &ProxyProtocolConfig{Timeout: DefaultProxyHeaderTimeout, MaxPending: DefaultProxyMaxPending}
+}
+
+// PROXY 协议v2 中的TLV 扩展字段
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// 常用的TLV 类型
+const (
+	ProxyTLVALPN      byte = 0x01
+	ProxyTLVAuthority byte = 0x02
+	ProxyTLVCRC32C    byte = 0x03
+	ProxyTLVNoop      byte = 0x04
+	ProxyTLVUniqueId  byte = 0x05
+	ProxyTLVSSL       byte = 0x20
+	ProxyTLVNetNS     byte = 0x30
+)
+
+/**
+ * 解析出来的PROXY 协议头
+ * @author abram
+ */
+type ProxyHeader struct {
+	Version     int      // 1 或2
+	Local       bool     // v2 的LOCAL 命令或v1 的UNKNOWN，表示连接来自上游自己，地址不变
+	Source      net.Addr // 真实的客户端地址
+	Destination net.Addr // 客户端连接的地址
+	TLVs        []ProxyTLV
+}
+
+// 第一个指定类型的TLV
+func (header *ProxyHeader) TLV(typ byte) ([]byte, bool) {
+	for _, tlv := range header.TLVs {
+		if tlv.Type == typ {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// 编译好的配置
+type proxyProtocol struct {
+	trusted    []*net.IPNet
+	trustUnix  bool
+	required   bool
+	timeout    time.Duration
+	maxPending int
+}
+
+func newProxyProtocol(config *ProxyProtocolConfig) (*proxyProtocol, error) {
+	if len(config.Trusted) == 0 && !config.TrustUnix {
+		return nil, errors.New("ProxyProtocol.Trusted 不能为空。")
+	}
+	trusted, err := parseCIDRs(config.Trusted)
+	if err != nil {
+		return nil, err
+	}
+	proxy := &proxyProtocol{trusted: trusted, trustUnix: config.TrustUnix, required: config.Required,
+		timeout: config.Timeout, maxPending: config.MaxPending}
+	if proxy.timeout <= 0 {
+		proxy.timeout = DefaultProxyHeaderTimeout
+	}
+	if proxy.maxPending <= 0 {
+		proxy.maxPending = DefaultProxyMaxPending
+	}
+	return proxy, nil
+}
+
+// 是否解析这个地址发来的协议头
+func (proxy *proxyProtocol) trust(addr net.Addr) bool {
+	var ip net.IP
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UnixAddr:
+		return proxy.trustUnix
+	default:
+		return false
+	}
+	for _, ipNet := range proxy.trusted {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 带PROXY 协议头的连接，RemoteAddr 和LocalAddr 返回协议头中的地址
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+	header *ProxyHeader
+}
+
+func (conn *proxyConn) Read(buf []byte) (int, error) {
+	return conn.reader.Read(buf)
+}
+
+func (conn *proxyConn) RemoteAddr() net.Addr {
+	if conn.header != nil && conn.header.Source != nil {
+		return conn.header.Source
+	}
+	return conn.Conn.RemoteAddr()
+}
+
+func (conn *proxyConn) LocalAddr() net.Addr {
+	if conn.header != nil && conn.header.Destination != nil {
+		return conn.header.Destination
+	}
+	return conn.Conn.LocalAddr()
+}
+
+// 读取连接开头的协议头，没有协议头时header 为nil
+func (proxy *proxyProtocol) accept(conn net.Conn) (*proxyConn, error) {
+	conn.SetReadDeadline(time.Now().Add(proxy.timeout))
+	defer conn.SetReadDeadline(time.Time{})
+	reader := bufio.NewReader(conn)
+	header, err := readProxyHeader(reader)
+	if err != nil {
+		// 超时前一个字节都没有收到，可能是服务端先发数据的协议
+		if !errors.Is(err, os.ErrDeadlineExceeded) || reader.Buffered() > 0 || proxy.required {
+			return nil, err
+		}
+		header = nil
+	}
+	if header == nil && proxy.required {
+		return nil, ErrProxyHeaderMissing
+	}
+	return &proxyConn{Conn: conn, reader: reader, header: header}, nil
+}
+
+/**
+ * 读取PROXY 协议头，自动识别v1 和v2，开头不是协议头时返回nil 并且不消耗数据
+ * @author abram
+ * @param reader 连接的reader
+ * @return ProxyHeader
+ */
+func readProxyHeader(reader *bufio.Reader) (*ProxyHeader, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case proxyV1Prefix[0]:
+		prefix, err := reader.Peek(len(proxyV1Prefix))
+		if err != nil || string(prefix) != proxyV1Prefix {
+			return nil, ErrProxyHeaderInvalid
+		}
+		return readProxyV1(reader)
+	case proxyV2Signature[0]:
+		sig, err := reader.Peek(len(proxyV2Signature))
+		if err != nil || !bytes.Equal(sig, proxyV2Signature) {
+			return nil, ErrProxyHeaderInvalid
+		}
+		return readProxyV2(reader)
+	}
+	return nil, nil
+}
+
+// v1: PROXY TCP4 源地址 目的地址 源端口 目的端口\r\n
+func readProxyV1(reader *bufio.Reader) (*ProxyHeader, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLength {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, ErrProxyHeaderInvalid
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	header := &ProxyHeader{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		header.Local = true
+		return header, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrProxyHeaderInvalid
+	}
+	source, err := parseProxyV1Addr(fields[2], fields[4], fields[1] == "TCP4")
+	if err != nil {
+		return nil, err
+	}
+	destination, err := parseProxyV1Addr(fields[3], fields[5], fields[1] == "TCP4")
+	if err != nil {
+		return nil, err
+	}
+	header.Source, header.Destination = source, destination
+	return header, nil
+}
+
+func parseProxyV1Addr(host, port string, v4 bool) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil || (ip.To4() != nil) != v4 {
+		return nil, ErrProxyHeaderInvalid
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, ErrProxyHeaderInvalid
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// v2: 12 字节签名，版本和命令，地址族和协议，2 字节长度，地址，TLV
+func readProxyV2(reader *bufio.Reader) (*ProxyHeader, error) {
+	var head [proxyV2HeaderLen]byte
+	if _, err := io.ReadFull(reader, head[:]); err != nil {
+		return nil, err
+	}
+	if head[12]>>4 != 2 {
+		return nil, ErrProxyHeaderInvalid
+	}
+	command := head[12] & 0x0f
+	if command > 1 {
+		return nil, ErrProxyHeaderInvalid
+	}
+	body := make([]byte, binary.BigEndian.Uint16(head[14:16]))
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, err
+	}
+
+	header := &ProxyHeader{Version: 2, Local: command == 0}
+	var addrLen int
+	switch head[13] >> 4 {
+	case 0x1: // IPv4
+		addrLen = 12
+		if len(body) < addrLen {
+			return nil, ErrProxyHeaderInvalid
+		}
+		header.Source, header.Destination = proxyV2Addrs(head[13]&0x0f, body[0:4], body[4:8], body[8:10], body[10:12])
+	case 0x2: // IPv6
+		addrLen = 36
+		if len(body) < addrLen {
+			return nil, ErrProxyHeaderInvalid
+		}
+		header.Source, header.Destination = proxyV2Addrs(head[13]&0x0f, body[0:16], body[16:32], body[32:34], body[34:36])
+	case 0x3: // unix
+		addrLen = 216
+		if len(body) < addrLen {
+			return nil, ErrProxyHeaderInvalid
+		}
+		header.Source = &net.UnixAddr{Name: string(bytes.TrimRight(body[0:108], "\x00")), Net: "unix"}
+		header.Destination = &net.UnixAddr{Name: string(bytes.TrimRight(body[108:216], "\x00")), Net: "unix"}
+	default: // 未知的地址族，按规范忽略后面的内容，使用连接本身的地址
+		addrLen = len(body)
+	}
+
+	tlvs := body[addrLen:]
+	for len(tlvs) > 0 {
+		if len(tlvs) < 3 {
+			return nil, ErrProxyHeaderInvalid
+		}
+		size := int(binary.BigEndian.Uint16(tlvs[1:3]))
+		if len(tlvs) < 3+size {
+			return nil, ErrProxyHeaderInvalid
+		}
+		header.TLVs = append(header.TLVs, ProxyTLV{Type: tlvs[0], Value: tlvs[3 : 3+size]})
+		tlvs = tlvs[3+size:]
+	}
+	if header.Local {
+		header.Source, header.Destination = nil, nil
+	}
+	return header, nil
+}
+
+func proxyV2Addrs(protocol byte, src, dst, srcPort, dstPort []byte) (net.Addr, net.Addr) {
+	sport := int(binary.BigEndian.Uint16(srcPort))
+	dport := int(binary.BigEndian.Uint16(dstPort))
+	srcIP := net.IP(append([]byte(nil), src...))
+	dstIP := net.IP(append([]byte(nil), dst...))
+	if protocol == 0x2 {
+		return &net.UDPAddr{IP: srcIP, Port: sport}, &net.UDPAddr{IP: dstIP, Port: dport}
+	}
+	return &net.TCPAddr{IP: srcIP, Port: sport}, &net.TCPAddr{IP: dstIP, Port: dport}
+}
+
+// 解析PROXY 协议头的listener，每个连接在自己的协程中解析，慢的连接不会阻塞Accept
+type proxyListener struct {
+	net.Listener
+	proxy     *proxyProtocol
+	pending   chan struct{} // 正在读取协议头的连接，满了之后暂停Accept
+	conns     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+	startOnce sync.Once
+	closeOnce sync.Once
+}
+
+/**
+ * 包装listener，Accept 返回的连接的RemoteAddr 是协议头中的客户端地址，
+ * 要在tls.NewListener 之前包装
+ * @author abram
+ * @param listener 原始的listener
+ * @param config PROXY 协议配置
+ * @return net.Listener
+ */
+func NewProxyListener(listener net.Listener, config *ProxyProtocolConfig) (net.Listener, error) {
+	proxy, err := newProxyProtocol(config)
+	if err != nil {
+		return nil, err
+	}
+	return proxy.listener(listener), nil
+}
+
+func (proxy *proxyProtocol) listener(listener net.Listener) net.Listener {
+	return &proxyListener{
+		Listener: listener,
+		proxy:    proxy,
+		pending:  make(chan struct{}, proxy.maxPending),
+		conns:    make(chan net.Conn),
+		errs:     make(chan error),
+		done:     make(chan struct{}),
+	}
+}
+
+func (listener *proxyListener) Accept() (net.Conn, error) {
+	listener.startOnce.Do(func() { go listener.acceptLoop() })
+	select {
+	case conn := <-listener.conns:
+		return conn, nil
+	case err := <-listener.errs:
+		return nil, err
+	case <-listener.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (listener *proxyListener) acceptLoop() {
+	for {
+		conn, err := listener.Listener.Accept()
+		if err != nil {
+			select {
+			case listener.errs <- err:
+			case <-listener.done:
+				return
+			}
+			if !isTemporaryAcceptError(err) {
+				return
+			}
+			continue
+		}
+		if !listener.proxy.trust(conn.RemoteAddr()) {
+			listener.deliver(conn)
+			continue
+		}
+		select {
+		case listener.pending <- struct{}{}:
+		case <-listener.done:
+			conn.Close()
+			return
+		}
+		go func() {
+			defer func() { <-listener.pending }()
+			pc, err := listener.proxy.accept(conn)
+			if err != nil {
+				log.Println("PROXY 协议头错误: ", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+			listener.deliver(pc)
+		}()
+	}
+}
+
+// 把连接交给Accept，listener 关闭后直接关闭连接
+func (listener *proxyListener) deliver(conn net.Conn) {
+	select {
+	case listener.conns <- conn:
+	case <-listener.done:
+		conn.Close()
+	}
+}
+
+func (listener *proxyListener) Close() error {
+	listener.closeOnce.Do(func() { close(listener.done) })
+	return listener.Listener.Close()
+}
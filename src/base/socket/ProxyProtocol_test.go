@@ -0,0 +1,270 @@
+package socket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadProxyHeaderV1(t *testing.T) {
+	reader := bufio.NewReader(strings.NewReader("PROXY TCP4 203.0.113.7 10.0.0.1 4000 8888\r\nrest"))
+	header, err := readProxyHeader(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != 1 || header.Source.String() != "203.0.113.7:4000" || header.Destination.String() != "10.0.0.1:8888" {
+		t.Fatalf("unexpected header %+v", header)
+	}
+	if rest, _ := ioutil.ReadAll(reader); string(rest) != "rest" {
+		t.Fatalf("header consumed too much: %q", rest)
+	}
+
+	// 没有协议头时不消耗数据
+	reader = bufio.NewReader(bytes.NewReader([]byte{0, 0, 1}))
+	if header, err := readProxyHeader(reader); header != nil || err != nil {
+		t.Fatalf("expected no header, got %v %v", header, err)
+	}
+	if reader.Buffered() != 3 {
+		t.Fatal("data without header should not be consumed")
+	}
+
+	for _, bad := range []string{"PROXY TCP4 1.2.3.4\r\n", "PROXY TCP4 ::1 ::1 1 2\r\n", "PROXY TCP4 1.2.3.4 1.2.3.4 1 2\n"} {
+		if _, err := readProxyHeader(bufio.NewReader(strings.NewReader(bad))); err != ErrProxyHeaderInvalid {
+			t.Errorf("%q: expected ErrProxyHeaderInvalid, got %v", bad, err)
+		}
+	}
+}
+
+// 生成v2 协议头
+func proxyV2Header(command byte, src, dst *net.TCPAddr, tlvs ...ProxyTLV) []byte {
+	var body bytes.Buffer
+	body.Write(src.IP.To4())
+	body.Write(dst.IP.To4())
+	binary.Write(&body, binary.BigEndian, uint16(src.Port))
+	binary.Write(&body, binary.BigEndian, uint16(dst.Port))
+	for _, tlv := range tlvs {
+		body.WriteByte(tlv.Type)
+		binary.Write(&body, binary.BigEndian, uint16(len(tlv.Value)))
+		body.Write(tlv.Value)
+	}
+	var header bytes.Buffer
+	header.Write(proxyV2Signature)
+	header.WriteByte(0x20 | command)
+	header.WriteByte(0x11) // TCP over IPv4
+	binary.Write(&header, binary.BigEndian, uint16(body.Len()))
+	header.Write(body.Bytes())
+	return header.Bytes()
+}
+
+func TestReadProxyHeaderV2(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("198.51.100.2"), Port: 51000}
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 443}
+	data := proxyV2Header(1, src, dst, ProxyTLV{Type: ProxyTLVAuthority, Value: []byte("game.example.com")}, ProxyTLV{Type: ProxyTLVUniqueId, Value: []byte{1, 2, 3}})
+	reader := bufio.NewReader(bytes.NewReader(append(data, 'x')))
+	header, err := readProxyHeader(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != 2 || header.Local || header.Source.String() != src.String() || header.Destination.String() != dst.String() {
+		t.Fatalf("unexpected header %+v", header)
+	}
+	if v, ok := header.TLV(ProxyTLVAuthority); !ok || string(v) != "game.example.com" {
+		t.Fatalf("authority TLV = %q, %v", v, ok)
+	}
+	if len(header.TLVs) != 2 {
+		t.Fatalf("expected 2 TLVs, got %d", len(header.TLVs))
+	}
+	if b, _ := reader.ReadByte(); b != 'x' {
+		t.Fatal("header consumed too much")
+	}
+
+	// LOCAL 命令使用连接本身的地址
+	header, err = readProxyHeader(bufio.NewReader(bytes.NewReader(proxyV2Header(0, src, dst))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !header.Local || header.Source != nil {
+		t.Fatalf("LOCAL header should not carry addresses: %+v", header)
+	}
+
+	// TLV 长度超出
+	data = proxyV2Header(1, src, dst, ProxyTLV{Type: ProxyTLVNoop, Value: []byte{0}})
+	binary.BigEndian.PutUint16(data[len(data)-3:], 5)
+	if _, err := readProxyHeader(bufio.NewReader(bytes.NewReader(data))); err != ErrProxyHeaderInvalid {
+		t.Fatalf("expected ErrProxyHeaderInvalid, got %v", err)
+	}
+}
+
+// 发送协议头后返回服务端看到的地址和协议头
+func proxyRemoteAddr(t *testing.T, trusted string, header string) (net.Addr, *ProxyHeader) {
+	addr := freeAddr(t)
+	type accepted struct {
+		remote net.Addr
+		header *ProxyHeader
+	}
+	connected := make(chan accepted, 1)
+	proxyConfig := NewProxyProtocolConfig()
+	proxyConfig.Trusted = []string{trusted}
+	srvConfig := newTestConfig(addr)
+	srvConfig.ConnectedHandler = func(channel IChannel) {
+		connected <- accepted{channel.RemoteAddr(), channel.(*DefaultChannel).ProxyHeader()}
+	}
+	srvConfig.ProxyProtocol = proxyConfig
+	server := startTestServer(t, srvConfig)
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(header)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case v := <-connected:
+		return v.remote, v.header
+	case <-time.After(time.Second):
+		t.Fatal("connection not accepted")
+	}
+	return nil, nil
+}
+
+func TestServerProxyProtocol(t *testing.T) {
+	remote, header := proxyRemoteAddr(t, "127.0.0.0/8", "PROXY TCP4 203.0.113.7 10.0.0.1 4000 8888\r\n")
+	if remote.String() != "203.0.113.7:4000" {
+		t.Fatalf("RemoteAddr() = %v, want the address from the header", remote)
+	}
+	if header == nil || header.Version != 1 {
+		t.Fatalf("ProxyHeader() = %+v", header)
+	}
+
+	// 不可信的上游发送的协议头不解析
+	remote, header = proxyRemoteAddr(t, "10.0.0.0/8", "PROXY TCP4 203.0.113.7 10.0.0.1 4000 8888\r\n")
+	if ip := remote.(*net.TCPAddr).IP; !ip.IsLoopback() {
+		t.Fatalf("untrusted peer spoofed its address: %v", remote)
+	}
+	if header != nil {
+		t.Fatal("header from untrusted peer should be ignored")
+	}
+}
+
+func TestProxyProtocolRequired(t *testing.T) {
+	proxyConfig := NewProxyProtocolConfig()
+	if _, err := NewProxyListener(nil, proxyConfig); err == nil {
+		t.Fatal("empty trusted list should fail")
+	}
+	proxyConfig.Trusted = []string{"127.0.0.1"}
+	proxyConfig.Required = true
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := NewProxyListener(l, proxyConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// 没有协议头的连接被关闭，后面带协议头的连接正常接受
+	bare, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bare.Close()
+	bare.Write([]byte{0, 0, 1})
+	proxied, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxied.Close()
+	proxied.Write([]byte("PROXY TCP6 2001:db8::1 2001:db8::2 5000 8888\r\n"))
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr().String() != "[2001:db8::1]:5000" {
+		t.Fatalf("RemoteAddr() = %v", conn.RemoteAddr())
+	}
+	bare.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := bare.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+		t.Fatalf("connection without header should be closed, got %v", err)
+	}
+}
+
+func TestProxyProtocolPending(t *testing.T) {
+	proxyConfig := &ProxyProtocolConfig{Trusted: []string{"127.0.0.1"}, MaxPending: 1}
+	proxy, err := newProxyProtocol(proxyConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proxy.timeout != DefaultProxyHeaderTimeout {
+		t.Fatalf("timeout %v, the header read must have a deadline", proxy.timeout)
+	}
+	proxyConfig.Timeout = 200 * time.Millisecond
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := NewProxyListener(l, proxyConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// 不发数据的连接超时后按没有协议头处理，它占着唯一的名额，后面的连接要等它
+	silent, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	time.Sleep(20 * time.Millisecond)
+	proxied, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxied.Close()
+	proxied.Write([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 4000 8888\r\n"))
+
+	first, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	if first.RemoteAddr().String() != silent.LocalAddr().String() {
+		t.Fatalf("first accepted %v, the silent connection should hold the only slot", first.RemoteAddr())
+	}
+	second, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if second.RemoteAddr().String() != "203.0.113.7:4000" {
+		t.Fatalf("RemoteAddr() = %v", second.RemoteAddr())
+	}
+}
+
+func TestProxyProtocolTrustUnix(t *testing.T) {
+	unixAddr := &net.UnixAddr{Name: "/tmp/socket", Net: "unix"}
+	proxy, err := newProxyProtocol(&ProxyProtocolConfig{Trusted: []string{"10.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proxy.trust(unixAddr) {
+		t.Fatal("unix peers should not be trusted by default")
+	}
+	proxy, err = newProxyProtocol(&ProxyProtocolConfig{TrustUnix: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proxy.trust(unixAddr) || proxy.trust(&net.TCPAddr{IP: net.IPv4(10, 0, 0, 1)}) {
+		t.Fatal("TrustUnix should trust only unix peers")
+	}
+}
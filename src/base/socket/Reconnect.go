@@ -0,0 +1,86 @@
+package socket
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+var (
+	ErrQueueFull         = errors.New("待发送队列已满。")
+	ErrReconnectExceeded = errors.New("超过最大重连次数。")
+)
+
+// MaxInterval 为0 时的最大重连间隔
+const DefaultMaxReconnectInterval = 30 * time.Second
+
+/**
+ * 客户端断线重连策略，重连间隔按指数退避增长并加上随机抖动
+ * @author abram
+ */
+type ReconnectPolicy struct {
+	InitialInterval time.Duration // 第一次重连前的等待时间
+	MaxInterval     time.Duration // 最大重连间隔，0 时取DefaultMaxReconnectInterval
+	Multiplier      float64       // 每次失败后间隔的增长倍数
+	Jitter          float64       // 随机抖动比例，0~1
+	MaxAttempts     int           // 连续重连失败的最大次数，0 表示不限
+	QueueSize       int           // 重连期间缓存的待发送数据包个数，0 表示不缓存
+}
+
+/**
+ * 生成一个默认的重连策略
+ * @author abram
+ * @return ReconnectPolicy
+ */
+func NewReconnectPolicy() *ReconnectPolicy {
+	return &ReconnectPolicy{
+		InitialInterval: 100 * time.Millisecond,
+		MaxInterval:     DefaultMaxReconnectInterval,
+		Multiplier:      2,
+		Jitter:          0.2,
+		QueueSize:       1024,
+	}
+}
+
+/**
+ * 第attempt 次（从1开始）重连前的等待时间
+ * @author abram
+ * @param attempt 重连次数
+ * @return 等待时间
+ */
+func (policy *ReconnectPolicy) Backoff(attempt int) time.Duration {
+	interval := float64(policy.InitialInterval)
+	if interval <= 0 {
+		interval = float64(100 * time.Millisecond)
+	}
+	multiplier := policy.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	limit := float64(policy.MaxInterval)
+	if limit <= 0 {
+		limit = float64(DefaultMaxReconnectInterval)
+	}
+	if attempt > 1 {
+		// 次数很多时乘方会变成+Inf，先限制再转换成Duration，避免溢出成负数
+		interval *= math.Pow(multiplier, float64(attempt-1))
+	}
+	if interval > limit || math.IsNaN(interval) {
+		interval = limit
+	}
+	if policy.Jitter > 0 {
+		jitter := math.Min(policy.Jitter, 1)
+		interval += interval * jitter * (rand.Float64()*2 - 1)
+	}
+	// 抖动之后也不超过最大间隔，算出非正数时按最大间隔等待
+	if interval <= 0 || interval > limit {
+		interval = limit
+	}
+	return time.Duration(interval)
+}
+
+// 是否还可以继续重连
+func (policy *ReconnectPolicy) allow(attempt int) bool {
+	return policy.MaxAttempts <= 0 || attempt <= policy.MaxAttempts
+}
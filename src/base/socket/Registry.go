@@ -0,0 +1,107 @@
+package socket
+
+import (
+	"reflect"
+	"sync"
+)
+
+/**
+ * 连接注册表，按连接id保存当前打开的连接，可以并发访问
+ * @author abram
+ */
+type ChannelRegistry struct {
+	lock     sync.RWMutex
+	channels map[uint64]IChannel
+}
+
+/**
+ * 生成一个连接注册表
+ * @author abram
+ * @return ChannelRegistry
+ */
+func NewChannelRegistry() *ChannelRegistry {
+	return &ChannelRegistry{channels: make(map[uint64]IChannel)}
+}
+
+// 注册连接
+func (registry *ChannelRegistry) Add(channel IChannel) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.channels[channel.Id()] = channel
+}
+
+// 移除连接
+func (registry *ChannelRegistry) Remove(channel IChannel) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	delete(registry.channels, channel.Id())
+}
+
+// 根据连接id查找连接
+func (registry *ChannelRegistry) Get(id uint64) (IChannel, bool) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	channel, ok := registry.channels[id]
+	return channel, ok
+}
+
+// 当前连接数
+func (registry *ChannelRegistry) Count() int {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	return len(registry.channels)
+}
+
+/**
+ * 遍历所有连接，fn 返回false 时停止遍历
+ * 遍历的是调用时的快照，fn 中可以安全地增删连接
+ * @author abram
+ * @param fn 遍历函数
+ */
+func (registry *ChannelRegistry) Range(fn func(channel IChannel) bool) {
+	for _, channel := range registry.All() {
+		if !fn(channel) {
+			return
+		}
+	}
+}
+
+// 获取所有连接
+func (registry *ChannelRegistry) All() []IChannel {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	channels := make([]IChannel, 0, len(registry.channels))
+	for _, channel := range registry.channels {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+// 查找满足条件的连接
+func (registry *ChannelRegistry) Find(filter func(channel IChannel) bool) []IChannel {
+	var channels []IChannel
+	registry.Range(func(channel IChannel) bool {
+		if filter(channel) {
+			channels = append(channels, channel)
+		}
+		return true
+	})
+	return channels
+}
+
+// 查找属性key 的值等于val 的连接，属性由IChannel.SetAttribute 设置
+func (registry *ChannelRegistry) FindByAttribute(key string, val interface{}) []IChannel {
+	return registry.Find(attributeFilter(key, val))
+}
+
+// 属性key 的值等于val 的连接数
+func (registry *ChannelRegistry) CountByAttribute(key string, val interface{}) int {
+	return len(registry.FindByAttribute(key, val))
+}
+
+func attributeFilter(key string, val interface{}) func(channel IChannel) bool {
+	return func(channel IChannel) bool {
+		v, ok := channel.GetAttribute(key)
+		return ok && reflect.DeepEqual(v, val)
+	}
+}
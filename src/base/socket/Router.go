@@ -0,0 +1,174 @@
+package socket
+
+import (
+	"log"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// 消息处理函数
+type HandlerFunc func(channel IChannel, protoPack *ProtoPack)
+
+// 中间件，包装下一个处理函数
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// 按平台号和消息id注册的路由键
+type routeKey struct {
+	platformId byte
+	id         int16
+}
+
+/**
+ * 消息路由器，按ProtoPack.Id（可选再按PlatformId）分发消息
+ * 可以直接作为Config.MessageHandler 使用：config.MessageHandler = router.Dispatch
+ * @author abram
+ */
+type Router struct {
+	mutex            sync.RWMutex
+	handlers         map[int16]HandlerFunc
+	platformHandlers map[routeKey]HandlerFunc
+	unknownHandler   HandlerFunc
+	middlewares      []Middleware
+	messages         *MessageRegistry // HandleMessage 使用的消息注册表
+}
+
+/**
+ * 生成一个路由器
+ * @author abram
+ * @return Router
+ */
+func NewRouter() *Router {
+	return &Router{
+		handlers:         make(map[int16]HandlerFunc),
+		platformHandlers: make(map[routeKey]HandlerFunc),
+		messages:         DefaultMessageRegistry,
+	}
+}
+
+// 设置HandleMessage 使用的消息注册表，默认为DefaultMessageRegistry
+func (router *Router) SetMessageRegistry(messages *MessageRegistry) {
+	router.mutex.Lock()
+	defer router.mutex.Unlock()
+	router.messages = messages
+}
+
+var channelType = reflect.TypeOf((*IChannel)(nil)).Elem()
+
+/**
+ * 注册处理已解码消息的函数，handler 的类型为func(channel IChannel, msg *T)，
+ * *T 必须已经在消息注册表中注册，消息id 由注册表决定，消息体按连接的序列化方式解码
+ * @author abram
+ * @param handler 处理函数
+ */
+func (router *Router) HandleMessage(handler interface{}) {
+	fn := reflect.ValueOf(handler)
+	t := fn.Type()
+	if t.Kind() != reflect.Func || t.NumIn() != 2 || t.NumOut() != 0 || t.In(0) != channelType {
+		panic("socket:Router.HandleMessage handler must be func(IChannel, *T)")
+	}
+	router.mutex.RLock()
+	messages := router.messages
+	router.mutex.RUnlock()
+	id, ok := messages.typeId(t.In(1))
+	if !ok {
+		panic("socket:Router.HandleMessage message type " + t.In(1).String() + " is not registered")
+	}
+
+	router.Handle(id, func(channel IChannel, protoPack *ProtoPack) {
+		msg, err := messages.UnmarshalWith(SerializerOf(channel), protoPack)
+		if err != nil {
+			log.Println("Unmarshal message error:", protoPack.Id, err)
+			return
+		}
+		fn.Call([]reflect.Value{reflect.ValueOf(&channel).Elem(), reflect.ValueOf(msg)})
+	})
+}
+
+/**
+ * 注册消息处理函数
+ * @author abram
+ * @param id 消息id
+ * @param handler 处理函数
+ */
+func (router *Router) Handle(id int16, handler HandlerFunc) {
+	if handler == nil {
+		panic("socket:Router.Handle handler is nil")
+	}
+	router.mutex.Lock()
+	defer router.mutex.Unlock()
+	if _, dup := router.handlers[id]; dup {
+		panic("socket:Router.Handle called twice for id " + strconv.Itoa(int(id)))
+	}
+	router.handlers[id] = handler
+}
+
+/**
+ * 注册指定平台的消息处理函数，优先于Handle注册的处理函数
+ * @author abram
+ * @param platformId 平台号
+ * @param id 消息id
+ * @param handler 处理函数
+ */
+func (router *Router) HandlePlatform(platformId byte, id int16, handler HandlerFunc) {
+	if handler == nil {
+		panic("socket:Router.HandlePlatform handler is nil")
+	}
+	key := routeKey{platformId: platformId, id: id}
+	router.mutex.Lock()
+	defer router.mutex.Unlock()
+	if _, dup := router.platformHandlers[key]; dup {
+		panic("socket:Router.HandlePlatform called twice for platform " +
+			strconv.Itoa(int(platformId)) + " id " + strconv.Itoa(int(id)))
+	}
+	router.platformHandlers[key] = handler
+}
+
+// 设置未知消息id的处理函数，没有设置时未知消息直接丢弃
+func (router *Router) HandleUnknown(handler HandlerFunc) {
+	router.mutex.Lock()
+	defer router.mutex.Unlock()
+	router.unknownHandler = handler
+}
+
+// 添加中间件，先添加的在外层
+func (router *Router) Use(middlewares ...Middleware) {
+	router.mutex.Lock()
+	defer router.mutex.Unlock()
+	router.middlewares = append(router.middlewares, middlewares...)
+}
+
+// 查找消息对应的处理函数，找不到时返回未知消息处理函数
+func (router *Router) lookup(protoPack *ProtoPack) (HandlerFunc, []Middleware) {
+	router.mutex.RLock()
+	defer router.mutex.RUnlock()
+
+	handler, ok := router.platformHandlers[routeKey{platformId: protoPack.PlatformId, id: protoPack.Id}]
+	if !ok {
+		handler, ok = router.handlers[protoPack.Id]
+	}
+	if !ok {
+		handler = router.unknownHandler
+	}
+	return handler, router.middlewares
+}
+
+/**
+ * 分发消息，签名与Config.MessageHandler 一致
+ * @author abram
+ * @param channel 连接
+ * @param protoPack 数据包
+ */
+func (router *Router) Dispatch(channel IChannel, protoPack *ProtoPack) {
+	if protoPack == nil {
+		return
+	}
+	handler, middlewares := router.lookup(protoPack)
+	if handler == nil {
+		return
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	handler(channel, protoPack)
+}
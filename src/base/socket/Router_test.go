@@ -0,0 +1,58 @@
+package socket
+
+import (
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	router := NewRouter()
+	var got []string
+	router.Handle(1, func(channel IChannel, protoPack *ProtoPack) {
+		got = append(got, "id1")
+	})
+	router.HandlePlatform(7, 1, func(channel IChannel, protoPack *ProtoPack) {
+		got = append(got, "platform7")
+	})
+	router.HandleUnknown(func(channel IChannel, protoPack *ProtoPack) {
+		got = append(got, "unknown")
+	})
+	router.Use(func(next HandlerFunc) HandlerFunc {
+		return func(channel IChannel, protoPack *ProtoPack) {
+			got = append(got, "outer")
+			next(channel, protoPack)
+		}
+	}, func(next HandlerFunc) HandlerFunc {
+		return func(channel IChannel, protoPack *ProtoPack) {
+			got = append(got, "inner")
+			next(channel, protoPack)
+		}
+	})
+
+	config := NewConfig()
+	config.MessageHandler = router.Dispatch
+
+	config.MessageHandler(nil, &ProtoPack{Id: 1})
+	config.MessageHandler(nil, &ProtoPack{Id: 1, PlatformId: 7})
+	config.MessageHandler(nil, &ProtoPack{Id: 2})
+
+	want := []string{"outer", "inner", "id1", "outer", "inner", "platform7", "outer", "inner", "unknown"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestRouterDuplicate(t *testing.T) {
+	router := NewRouter()
+	router.Handle(1, func(channel IChannel, protoPack *ProtoPack) {})
+	defer func() {
+		if recover() == nil {
+			t.Fatal("duplicate Handle should panic")
+		}
+	}()
+	router.Handle(1, func(channel IChannel, protoPack *ProtoPack) {})
+}
@@ -0,0 +1,129 @@
+package socket
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+const (
+	Protobuf = "protobuf"
+	JSON     = "json"
+	MsgPack  = "msgpack"
+
+	SerializerAttribute = "socket.serializer" // 连接使用的序列化方式在IChannel 属性中的key，值为名字或Serializer
+)
+
+/**
+ * 消息体序列化接口，MessageRegistry 用它在消息和ProtoPack.Body 之间转换
+ * @author abram
+ */
+type Serializer interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	serializerLock sync.RWMutex
+	serializers    = make(map[string]Serializer)
+)
+
+/**
+ * 注册序列化方式
+ * @author abram
+ * @param name 序列化方式的名字
+ * @param serializer 序列化方式
+ */
+func RegisterSerializer(name string, serializer Serializer) {
+	if serializer == nil {
+		panic("socket:RegisterSerializer serializer is nil")
+	}
+	serializerLock.Lock()
+	defer serializerLock.Unlock()
+	if _, dup := serializers[name]; dup {
+		panic("socket:RegisterSerializer called twice for serializer " + name)
+	}
+	serializers[name] = serializer
+}
+
+// 按名字查找序列化方式
+func LookupSerializer(name string) (Serializer, bool) {
+	serializerLock.RLock()
+	defer serializerLock.RUnlock()
+	serializer, ok := serializers[name]
+	return serializer, ok
+}
+
+// protobuf 序列化，消息要实现ProtoMessage
+type protobufSerializer struct{}
+
+func (serializer protobufSerializer) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(ProtoMessage)
+	if !ok {
+		return nil, ErrNotProtoMessage
+	}
+	return msg.Marshal()
+}
+
+func (serializer protobufSerializer) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(ProtoMessage)
+	if !ok {
+		return ErrNotProtoMessage
+	}
+	return msg.Unmarshal(data)
+}
+
+// JSON 序列化，基于encoding/json
+type jsonSerializer struct{}
+
+func (serializer jsonSerializer) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (serializer jsonSerializer) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// 默认的序列化方式
+var ProtobufSerializer Serializer = protobufSerializer{}
+
+func init() {
+	RegisterSerializer(Protobuf, ProtobufSerializer)
+	RegisterSerializer(JSON, jsonSerializer{})
+	RegisterSerializer(MsgPack, MsgPackSerializer{})
+}
+
+/**
+ * 取连接使用的序列化方式：先看SerializerAttribute 属性，
+ * 再看Config.Serializer，都没有时使用ProtobufSerializer
+ * @author abram
+ * @param channel 连接，可以为nil
+ * @return Serializer
+ */
+func SerializerOf(channel IChannel) Serializer {
+	if v, ok := channel.(interface{ Serializer() Serializer }); ok {
+		return v.Serializer()
+	}
+	if channel != nil {
+		if serializer := attributeSerializer(channel); serializer != nil {
+			return serializer
+		}
+	}
+	return ProtobufSerializer
+}
+
+// SerializerAttribute 属性中设置的序列化方式
+func attributeSerializer(channel IChannel) Serializer {
+	v, ok := channel.GetAttribute(SerializerAttribute)
+	if !ok {
+		return nil
+	}
+	switch v := v.(type) {
+	case Serializer:
+		return v
+	case string:
+		if serializer, ok := LookupSerializer(v); ok {
+			return serializer
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package socket
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type testProfile struct {
+	Name   string `msgpack:"name"`
+	Level  int32  `json:"level"`
+	Score  float64
+	Tags   []string          `msgpack:"tags"`
+	Extra  map[string]uint16 `msgpack:"extra"`
+	Avatar []byte            `msgpack:"avatar"`
+	Secret string            `msgpack:"-"`
+}
+
+func TestMsgPackSerializer(t *testing.T) {
+	serializer := MsgPackSerializer{}
+	data, err := serializer.Marshal(map[string]interface{}{"a": 1})
+	if err != nil || !bytes.Equal(data, []byte{0x81, 0xa1, 'a', 0x01}) {
+		t.Fatalf("unexpected encoding % x, %v", data, err)
+	}
+
+	in := testProfile{Name: "abram", Level: -300, Score: 1.5, Tags: []string{"x", "y"},
+		Extra: map[string]uint16{"gold": 65535}, Avatar: []byte{1, 2, 3}, Secret: "hidden"}
+	if data, err = serializer.Marshal(&in); err != nil {
+		t.Fatal(err)
+	}
+	var out testProfile
+	if err := serializer.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	in.Secret = ""
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("got %+v, want %+v", out, in)
+	}
+
+	if err := serializer.Unmarshal([]byte{0xdd, 0xff, 0xff, 0xff, 0xff}, &out); err == nil {
+		t.Fatal("truncated array should fail")
+	}
+}
+
+func TestMsgPackDepthLimit(t *testing.T) {
+	serializer := MsgPackSerializer{}
+	// 8MB 的单元素数组嵌套，没有深度限制时会栈溢出
+	data := append(bytes.Repeat([]byte{0x91}, 8<<20), 0x01)
+	var out interface{}
+	if err := serializer.Unmarshal(data, &out); err != ErrMsgPackTooDeep {
+		t.Fatalf("expected ErrMsgPackTooDeep, got %v", err)
+	}
+	data = append(bytes.Repeat([]byte{0x81, 0x01}, 200), 0x01)
+	if err := serializer.Unmarshal(data, &out); err != ErrMsgPackTooDeep {
+		t.Fatalf("expected ErrMsgPackTooDeep for nested maps, got %v", err)
+	}
+
+	// 限制以内的嵌套正常解码
+	data = append(bytes.Repeat([]byte{0x91}, msgPackMaxDepth), 0x01)
+	if err := serializer.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSerializerPerChannel(t *testing.T) {
+	registry := NewMessageRegistry()
+	registry.Register(20, &testProfile{})
+	router := NewRouter()
+	router.SetMessageRegistry(registry)
+	var got string
+	router.HandleMessage(func(channel IChannel, msg *testProfile) {
+		got = msg.Name
+	})
+
+	for _, name := range []string{JSON, MsgPack} {
+		serializer, _ := LookupSerializer(name)
+		protoPack, err := registry.MarshalWith(serializer, &testProfile{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		channel := NewDefaultChannel(nil, nil)
+		channel.SetAttribute(SerializerAttribute, name)
+		router.Dispatch(channel, &protoPack)
+		if got != name {
+			t.Fatalf("%s: got %q", name, got)
+		}
+	}
+}
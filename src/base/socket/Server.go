@@ -2,14 +2,22 @@ package socket
 
 import (
 	//"bytes"
+	"context"
+	"crypto/tls"
 	"errors"
 	"log"
+	"net"
+	"net/http"
+	"os"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 var (
 	tcp string = "tcp"
+	udp string = "udp"
 	ip4 string = "ip4"
 )
 
@@ -20,11 +28,35 @@ const (
 
 type Config struct {
 	CloseingTimeout   time.Duration //关闭连接的超时时间
-	Addr              string        //监听地址
+	Addr              string        //监听地址，unix:///path 表示unix 套接字，客户端连接时可以使用ws:// 或wss:// 地址
 	CodecFactory      ICodecFactory
 	ConnectedHandler  func(channel IChannel)
 	DisconnectHandler func(channel IChannel)
-	MessageHandler    func(channel IChannel, protoPack *ProtoPack) //业务处理函数
+	MessageHandler    func(channel IChannel, protoPack *ProtoPack) //业务处理函数，可以使用Router.Dispatch按消息id分发
+	Reconnect         *ReconnectPolicy                             //客户端断线重连策略，nil 表示不重连
+	Heartbeat         *HeartbeatConfig                             //心跳和空闲检测配置，nil 表示不启用
+	IdleHandler       func(channel IChannel, state IdleState)      //空闲事件
+	TLS               *TLSConfig                                   //TLS 配置，nil 表示不加密
+	MaxFrameSize      int                                          //最大数据帧长度，0 表示DefaultMaxFrameSize
+	MaxBodySize       int                                          //最大消息体长度，0 表示DefaultMaxFrameSize
+	OversizeHandler   func(channel IChannel, err error)            //收到超长或长度为负数的数据时调用，之后连接会被关闭
+	Messages          *MessageRegistry                             //IChannel.Send 使用的消息注册表，nil 表示DefaultMessageRegistry
+	Serializer        Serializer                                   //消息体的序列化方式，nil 表示ProtobufSerializer，可以用SerializerAttribute 按连接修改
+	WebSocketAddr     string                                       //WebSocket 监听地址，为空表示不启用，配置了TLS 时使用wss
+	WebSocketPath     string                                       //WebSocket 的HTTP 路径，为空表示"/"
+	CheckOrigin       func(r *http.Request) bool                   //检查WebSocket 请求的Origin，nil 表示只允许同源或没有Origin 的请求
+	Network           string                                       //网络类型，"tcp"(默认) 或"udp"
+	UDP               *UDPConfig                                   //Network 为"udp" 时的传输配置，nil 表示使用默认值
+	UnixSocketMode    os.FileMode                                  //unix 套接字文件的权限，0 表示不修改
+	Dispatch          *DispatchConfig                              //消息处理的调度方式，nil 表示每个消息一个协程
+	WriteQueue        *WriteQueueConfig                            //异步写队列，nil 表示不使用：Write 在调用的协程中持有连接的锁直接写socket，每个数据帧单独写出
+	Limits            *LimitConfig                                 //连接数和消息频率限制，nil 表示不限制
+	IPFilter          *IPFilter                                    //连接的IP 过滤器，nil 表示不过滤
+	ProxyProtocol     *ProxyProtocolConfig                         //解析负载均衡发送的PROXY 协议头，nil 表示不解析
+	Instrumentation   Instrumentation                              //监控，Server 和Client 都会调用，可以使用NewMetrics，nil 表示不统计
+	PanicHandler      PanicHandler                                 //处理函数panic 时调用，nil 表示打印到日志
+	PanicPolicy       PanicPolicy                                  //处理函数panic 之后是否关闭连接，默认继续处理
+	ErrorHandler      func(channel IChannel, err error)            //服务端连接断开时调用，err 是断开的原因，对方正常关闭时也会调用，可以用IsNormalClose 或errors.Is 判断种类
 }
 
 /**
@@ -40,15 +72,45 @@ func NewConfig() *Config {
  * @author abram
  */
 type Server struct {
-	stopped          bool
+	stopped          int32 // 1 表示已经停止，accept 循环和Stop 在不同的协程中，用原子操作
 	closingTimeout   time.Duration
 	addr             string
 	codecFactory     ICodecFactory
 	mutex            sync.RWMutex
-	serverSocket     *ServerSocket
+	serverSocket     IServerSocket // 当前的监听，Serve 时是包装传进来的listener 的ServerSocket
+	listenSocket     IServerSocket // 按配置生成的监听，带TLS 和PROXY 协议设置，Start 总是使用它
 	connectedHandler func(channel IChannel)
 	disconnectHanler func(channel IChannel)
 	messageHandler   func(channel IChannel, protoPack *ProtoPack)
+	dispatcher       *dispatcher
+	writeQueue       *WriteQueueConfig
+	limiter          *limiter
+	network          string
+	ipFilter         atomic.Value // *IPFilter
+	proxyProtocol    *ProxyProtocolConfig
+	instrumentation  Instrumentation
+	panicGuard       *panicGuard
+	errorHandler     func(channel IChannel, err error)
+	heartbeat        *HeartbeatConfig
+	idleHandler      func(channel IChannel, state IdleState)
+	maxFrameSize     int
+	maxBodySize      int
+	oversizeHandler  func(channel IChannel, err error)
+	messages         *MessageRegistry
+	serializer       Serializer
+	tlsConfig        *tls.Config
+	handshakeTimeout time.Duration
+	websocketAddr    string
+	websocketPath    string
+	checkOrigin      func(r *http.Request) bool
+	httpServer       *http.Server
+	ready            chan struct{} // 开始监听后关闭，停止后换成新的
+	acceptDone       chan struct{} // 接受连接的循环退出后关闭
+
+	channelLock  sync.Mutex
+	channels     *ChannelRegistry // 当前打开的连接
+	connections  sync.WaitGroup   // 每个连接的处理协程
+	shuttingDown bool
 }
 
 /**
@@ -79,43 +141,263 @@ func NewServer(config *Config) (*Server, error) {
 	server.codecFactory = config.CodecFactory
 	server.connectedHandler = config.ConnectedHandler
 	server.messageHandler = config.MessageHandler
+	server.panicGuard = newPanicGuard(config.PanicHandler, config.PanicPolicy)
+	server.instrumentation = guardInstrumentation(config.Instrumentation, server.panicGuard)
+	server.dispatcher = newDispatcher(config.Dispatch, instrumentHandler(server.instrumentation, server.panicGuard.wrap(config.MessageHandler)))
+	server.errorHandler = config.ErrorHandler
+	server.writeQueue = config.WriteQueue
+	server.limiter = newLimiter(config.Limits, server.panicGuard)
+	server.network = config.Network
+	server.SetIPFilter(config.IPFilter)
+	server.proxyProtocol = config.ProxyProtocol
 	server.disconnectHanler = config.DisconnectHandler
+	server.heartbeat = config.Heartbeat
+	server.idleHandler = config.IdleHandler
+	server.maxFrameSize = config.MaxFrameSize
+	server.maxBodySize = config.MaxBodySize
+	server.oversizeHandler = config.OversizeHandler
+	server.messages = config.Messages
+	server.serializer = config.Serializer
+	server.websocketAddr = config.WebSocketAddr
+	server.websocketPath = config.WebSocketPath
+	server.checkOrigin = config.CheckOrigin
+	server.channels = NewChannelRegistry()
 
 	if server.closingTimeout == 0 {
 		server.closingTimeout = Closing_timeout
 	}
 
-	serverSocket, err := NewServerSocket(server.addr)
+	var serverSocket IServerSocket
+	var err error
+	switch {
+	case config.Network == udp:
+		if config.TLS != nil {
+			return nil, errors.New("UDP 不支持TLS。")
+		}
+		if config.ProxyProtocol != nil {
+			return nil, errors.New("UDP 不支持PROXY 协议。")
+		}
+		serverSocket, err = NewUDPServerSocket(server.addr, config.UDP)
+	case config.Network != "" && config.Network != tcp:
+		return nil, errors.New("不支持的网络类型：" + config.Network)
+	case config.TLS != nil:
+		if server.tlsConfig, err = config.TLS.ServerConfig(); err != nil {
+			return nil, err
+		}
+		server.handshakeTimeout = config.TLS.HandshakeTimeout
+		serverSocket, err = NewServerSocketTLS(server.addr, 0, server.tlsConfig)
+	default:
+		serverSocket, err = NewServerSocket(server.addr)
+	}
 	if err != nil {
 		return nil, err
 	}
+	if v, ok := serverSocket.(*ServerSocket); ok {
+		v.SetFileMode(config.UnixSocketMode)
+		v.SetHandshakeTimeout(server.handshakeTimeout)
+		if err := v.SetProxyProtocol(config.ProxyProtocol); err != nil {
+			return nil, err
+		}
+	}
 	server.serverSocket = serverSocket
-	server.stopped = true
+	server.listenSocket = serverSocket
+	server.ready = make(chan struct{})
+	server.setStopped(true)
 	return server, nil
 }
 
 /**
- * 启动服务器
+ * 启动服务器，阻塞到Stop 或Shutdown 为止，停止后可以再次启动。
+ * Accept 出现不能重试的错误时只关闭TCP 监听并返回错误，WebSocket 监听和已有的连接不受影响，
+ * 需要时调用Stop 或Shutdown
  * @author abram
  */
 func (server *Server) Start() error {
-	if !server.stopped {
+	done, ok := server.begin()
+	if !ok {
 		return errors.New("服务已经启动。")
 	}
+	// 之前调用过Serve 时换回按配置生成的监听
+	server.mutex.Lock()
+	server.serverSocket = server.listenSocket
+	server.mutex.Unlock()
+	return server.serve(context.Background(), true, done)
+}
 
-	server.stopped = false
-	err := server.serverSocket.Listen()
-	if err != nil {
-		return err
+/**
+ * 在已经绑定的listener 上启动服务，比如systemd 传进来的socket 或测试用的:0 端口，
+ * 配置了TLS 时会包装成TLS listener。ctx 取消后停止接受新连接并返回ctx.Err()，
+ * Accept 出错时和Start 一样处理
+ * @author abram
+ * @param ctx 控制服务的生命周期
+ * @param listener 已经绑定的listener
+ */
+func (server *Server) Serve(ctx context.Context, listener net.Listener) error {
+	if server.network == udp {
+		return errors.New("UDP 服务不能使用net.Listener。")
+	}
+	done, ok := server.begin()
+	if !ok {
+		return errors.New("服务已经启动。")
+	}
+	if server.proxyProtocol != nil {
+		var err error
+		if listener, err = NewProxyListener(listener, server.proxyProtocol); err != nil {
+			server.setStopped(true)
+			close(done)
+			return err
+		}
+	}
+	if server.tlsConfig != nil {
+		listener = tls.NewListener(listener, server.tlsConfig)
+	}
+	serverSocket := NewServerSocketFromListener(listener, 0)
+	serverSocket.SetHandshakeTimeout(server.handshakeTimeout)
+	server.mutex.Lock()
+	server.serverSocket = serverSocket
+	server.mutex.Unlock()
+	return server.serve(ctx, false, done)
+}
+
+/**
+ * 从停止状态切换到运行状态，等上一次接受连接的循环退出后返回，
+ * 避免它把新的监听当成出错关闭
+ * @author abram
+ * @return 这一次接受连接的循环退出后要关闭的chan，已经在运行时返回false
+ */
+func (server *Server) begin() (chan struct{}, bool) {
+	if !atomic.CompareAndSwapInt32(&server.stopped, 1, 0) {
+		return nil, false
+	}
+	done := make(chan struct{})
+	server.mutex.Lock()
+	prev := server.acceptDone
+	server.acceptDone = done
+	server.mutex.Unlock()
+	if prev != nil {
+		<-prev
+	}
+	server.channelLock.Lock()
+	server.shuttingDown = false
+	server.channelLock.Unlock()
+	server.dispatcher.start()
+	return done, true
+}
+
+// 当前的监听，Serve 会替换
+func (server *Server) listener() IServerSocket {
+	server.mutex.RLock()
+	defer server.mutex.RUnlock()
+	return server.serverSocket
+}
+
+// 开始监听后关闭的chan，用于等待在另一个协程中调用的Start 或Serve 准备好接受连接，
+// Stop 或Shutdown 后会换成新的chan
+func (server *Server) Ready() <-chan struct{} {
+	server.mutex.RLock()
+	defer server.mutex.RUnlock()
+	return server.ready
+}
+
+func (server *Server) markReady() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	select {
+	case <-server.ready:
+	default:
+		close(server.ready)
+	}
+}
+
+func (server *Server) resetReady() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	select {
+	case <-server.ready:
+		server.ready = make(chan struct{})
+	default:
+	}
+}
+
+// 实际监听的地址，比如Addr 为:0 时可以得到分配的端口
+func (server *Server) Addr() net.Addr {
+	return server.listener().Addr()
+}
+
+// 接受连接的错误是否可以重试，比如文件描述符用完了
+func isTemporaryAcceptError(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ENOBUFS) || errors.Is(err, syscall.ENOMEM) ||
+		errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+const (
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+)
+
+// 接受连接的循环，listen 为true 时先开始监听，退出时关闭done
+func (server *Server) serve(ctx context.Context, listen bool, done chan struct{}) error {
+	defer close(done)
+	serverSocket := server.listener()
+	if server.websocketAddr != "" {
+		if err := server.listenWebSocket(); err != nil {
+			server.setStopped(true)
+			return err
+		}
+	}
+	if listen {
+		if err := serverSocket.Listen(); err != nil {
+			server.closeWebSocket()
+			server.setStopped(true)
+			return err
+		}
 	}
 	log.Println("开始监听...")
-	for !server.stopped {
-		client, err := server.serverSocket.Accept()
+	server.markReady()
+
+	if ctx.Done() != nil {
+		exited := make(chan struct{})
+		defer close(exited)
+		go func() {
+			select {
+			case <-ctx.Done():
+				server.Stop()
+			case <-exited:
+			}
+		}()
+	}
+
+	var delay time.Duration
+	for !server.isStopped() {
+		server.limiter.waitAccept()
+		client, err := serverSocket.Accept()
 		if err != nil {
-			log.Println("Accept err: ", err)
+			if server.isStopped() {
+				break
+			}
+			if !isTemporaryAcceptError(err) {
+				// 只关闭出错的监听，WebSocket 和已有的连接由调用方决定是否关闭
+				log.Println("Accept err: ", err)
+				serverSocket.Interrupt()
+				return err
+			}
+			// 可以重试的错误，退避后再接受，避免空转
+			if delay == 0 {
+				delay = minAcceptDelay
+			} else if delay *= 2; delay > maxAcceptDelay {
+				delay = maxAcceptDelay
+			}
+			log.Println("Accept err: ", err, "; retrying in", delay)
+			time.Sleep(delay)
+			continue
 		}
-		if client != nil {
+		delay = 0
+		remoteAddr := transportRemoteAddr(client)
+		if server.track(client, remoteAddr) {
 			go func() {
+				defer server.untrack(remoteAddr)
 				if err := server.connectionHandler(client); err != nil {
 					log.Println("Error processing request:", err)
 				}
@@ -123,37 +405,234 @@ func (server *Server) Start() error {
 		}
 	}
 
+	return ctx.Err()
+}
+
+// 登记新连接的处理协程，停机中或超过连接限制时关闭连接并返回false，
+// 返回true 时连接处理完后要调用untrack。IP 过滤器、LimitConfig.Handler 和关闭连接都在锁外调用
+func (server *Server) track(client ITransport, remoteAddr net.Addr) bool {
+	if filter := server.IPFilter(); filter != nil && !filter.Allow(remoteAddr) {
+		log.Println("IP 被过滤: ", remoteAddr)
+		client.Close()
+		return false
+	}
+	if !server.limiter.admit(remoteAddr) {
+		client.Close()
+		return false
+	}
+	server.channelLock.Lock()
+	shuttingDown := server.shuttingDown
+	if !shuttingDown {
+		server.connections.Add(1)
+	}
+	server.channelLock.Unlock()
+	if shuttingDown {
+		server.limiter.release(remoteAddr)
+		client.Close()
+		return false
+	}
+	return true
+}
+
+// 替换IP 过滤器，nil 表示不过滤，只影响之后接受的连接
+func (server *Server) SetIPFilter(filter *IPFilter) {
+	server.ipFilter.Store(&filter)
+}
+
+// 当前的IP 过滤器
+func (server *Server) IPFilter() *IPFilter {
+	filter, _ := server.ipFilter.Load().(**IPFilter)
+	if filter == nil {
+		return nil
+	}
+	return *filter
+}
+
+// 连接处理完后释放连接数
+func (server *Server) untrack(remoteAddr net.Addr) {
+	server.limiter.release(remoteAddr)
+	server.connections.Done()
+}
+
+// 开始监听WebSocket 地址
+func (server *Server) listenWebSocket() error {
+	listener, err := net.Listen("tcp", server.websocketAddr)
+	if err != nil {
+		return err
+	}
+	if server.tlsConfig != nil {
+		listener = tls.NewListener(listener, server.tlsConfig)
+	}
+	path := server.websocketPath
+	if path == "" {
+		path = "/"
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, server)
+	// 限制读请求头的时间，慢速发送请求头的客户端不能一直占着连接
+	httpServer := &http.Server{Handler: mux, ReadHeaderTimeout: wsHeaderTimeout}
+	server.mutex.Lock()
+	server.httpServer = httpServer
+	server.mutex.Unlock()
+	go func() {
+		if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Println("WebSocket serve err: ", err)
+		}
+	}()
 	return nil
 }
 
+// 关闭WebSocket 监听，已经升级的连接不受影响
+func (server *Server) closeWebSocket() {
+	server.mutex.Lock()
+	httpServer := server.httpServer
+	server.httpServer = nil
+	server.mutex.Unlock()
+	if httpServer != nil {
+		httpServer.Close()
+	}
+}
+
+/**
+ * 把HTTP 请求升级为WebSocket 连接并按TCP 连接一样处理，
+ * 可以挂到已有的http.ServeMux 上，也可以通过Config.WebSocketAddr 单独监听
+ * @author abram
+ */
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	checkOrigin := server.checkOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	client, err := UpgradeWebSocket(w, r, 0)
+	if err != nil {
+		log.Println("WebSocket upgrade err: ", err)
+		return
+	}
+	remoteAddr := transportRemoteAddr(client)
+	if !server.track(client, remoteAddr) {
+		return
+	}
+	defer server.untrack(remoteAddr)
+	if err := server.connectionHandler(client); err != nil {
+		log.Println("Error processing request:", err)
+	}
+}
+
 /**
  * 客户端接入管理
  * @author abram
  * @param client ITransport 实际类型是Socket
  */
 func (server *Server) connectionHandler(client ITransport) error {
+	if v, ok := client.(handshaker); ok {
+		if err := v.Handshake(); err != nil {
+			client.Close()
+			return err
+		}
+	}
 	transport := NewFramedTransport(client)
+	transport.SetMaxFrameSize(server.maxFrameSize)
+	transport.SetInstrumentation(server.instrumentation)
 	codec := server.codecFactory.GetCodec(transport)
-	channel := NewDefaultChannel(client, codec)
+	setMaxBodySize(codec, server.maxBodySize)
+	channel := newDefaultChannel(client, transport, codec)
+	channel.messages = server.messages
+	channel.serializer = server.serializer
+	channel.startWriteQueue(server.writeQueue)
+	handlers := server.dispatcher.forChannel(channel)
+
+	server.channelLock.Lock()
+	server.channels.Add(channel)
+	if server.shuttingDown {
+		channel.interruptRead()
+	}
+	server.channelLock.Unlock()
 
 	defer func() {
+		server.channelLock.Lock()
+		server.channels.Remove(channel)
+		draining := server.shuttingDown
+		server.channelLock.Unlock()
+
+		handlers.close()
+		if draining {
+			// 停机时等处理中的消息处理完，把数据写出去再关闭
+			handlers.wait()
+		}
 		if server.disconnectHanler != nil {
-			server.disconnectHanler(channel)
+			server.panicGuard.call(channel, server.disconnectHanler)
+		}
+		if server.instrumentation != nil {
+			server.instrumentation.ConnectionClosed(channel)
+		}
+		channel.failPending()
+		if draining {
+			channel.FlushAndClose()
+		} else {
+			channel.Close()
 		}
-		channel.Close()
 		time.Sleep(500)
 	}()
 
-	server.connectedHandler(channel)
+	if server.instrumentation != nil {
+		server.instrumentation.ConnectionOpened(channel)
+	}
+	server.panicGuard.call(channel, server.connectedHandler)
+	stopHeartbeat := startHeartbeat(channel, server.heartbeat, server.idleHandler, server.panicGuard)
+	defer stopHeartbeat()
+	remoteAddr := transportRemoteAddr(client)
+	messageLimit := server.limiter.messageLimit()
+	var err error
 	for {
-		protoPack, err := codec.Decode()
+		var protoPack *ProtoPack
+		protoPack, err = codec.Decode()
 		if err != nil {
+			// 自定义的编码解码器可能返回原始错误
+			err = classifyError("read", channel.closeReason(err))
+			reportOversize(server.panicGuard, channel, err, server.oversizeHandler)
+			if server.instrumentation != nil && !isClosedError(err) {
+				server.instrumentation.DecodeError(channel, err)
+			}
+			break
+		}
+		if channel.received(protoPack) || channel.complete(protoPack) {
+			continue
+		}
+		if handle, disconnect := server.limiter.allowMessage(channel, remoteAddr, messageLimit); disconnect {
+			err = ErrRateLimited
 			break
+		} else if !handle {
+			continue
 		}
-		go server.messageHandler(channel, protoPack)
+		if server.instrumentation != nil {
+			server.instrumentation.MessageReceived(channel, protoPack)
+		}
+		handlers.dispatch(protoPack)
 	}
 
-	return nil
+	if err != nil && server.errorHandler != nil {
+		server.panicGuard.run(channel, func() { server.errorHandler(channel, err) })
+	}
+	if IsNormalClose(err) {
+		return nil
+	}
+	return err
+}
+
+func (server *Server) isStopped() bool {
+	return atomic.LoadInt32(&server.stopped) == 1
+}
+
+func (server *Server) setStopped(stopped bool) {
+	var v int32
+	if stopped {
+		v = 1
+	}
+	atomic.StoreInt32(&server.stopped, v)
 }
 
 /**
@@ -161,7 +640,108 @@ func (server *Server) connectionHandler(client ITransport) error {
  * @author abram
  */
 func (server *Server) Stop() error {
-	server.stopped = true
-	server.serverSocket.Interrupt()
+	server.setStopped(true)
+	server.resetReady()
+	server.listener().Interrupt()
+	server.closeWebSocket()
+	server.dispatcher.stop()
 	return nil
 }
+
+/**
+ * 平滑关闭服务：关闭监听，不再读取新的消息，等处理中的消息处理完后
+ * 把数据写出去并关闭所有连接
+ * @author abram
+ * @param ctx 超时后强制关闭剩余的连接并返回ctx.Err()
+ */
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.channelLock.Lock()
+	server.shuttingDown = true
+	server.setStopped(true)
+	server.resetReady()
+	server.listener().Interrupt()
+	server.closeWebSocket()
+	server.dispatcher.stop()
+	server.channels.Range(func(channel IChannel) bool {
+		channel.(*DefaultChannel).interruptRead()
+		return true
+	})
+	server.channelLock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		server.connections.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		server.channels.Range(func(channel IChannel) bool {
+			channel.Close()
+			return true
+		})
+		return ctx.Err()
+	}
+}
+
+// 连接注册表，可以按id或属性查找、遍历当前打开的连接
+func (server *Server) Channels() *ChannelRegistry {
+	return server.channels
+}
+
+// 根据连接id查找连接
+func (server *Server) Channel(id uint64) (IChannel, bool) {
+	return server.channels.Get(id)
+}
+
+// 当前连接数
+func (server *Server) ChannelCount() int {
+	return server.channels.Count()
+}
+
+/**
+ * 把数据包发给所有连接，数据包只编码一次
+ * @author abram
+ * @param protoPack 数据包
+ * @return 编码错误或最后一个发送错误
+ */
+func (server *Server) Broadcast(protoPack ProtoPack) error {
+	return server.Multicast(protoPack, server.channels.All())
+}
+
+// 把数据包发给属性key 的值等于val 的连接，比如某个平台的所有玩家
+func (server *Server) MulticastByAttribute(key string, val interface{}, protoPack ProtoPack) error {
+	return server.Multicast(protoPack, server.channels.FindByAttribute(key, val))
+}
+
+/**
+ * 把数据包发给指定的连接，数据包只编码一次，设置了自己密钥的连接单独编码
+ * @author abram
+ * @param protoPack 数据包
+ * @param channels 连接列表
+ * @return 编码错误或最后一个发送错误
+ */
+func (server *Server) Multicast(protoPack ProtoPack, channels []IChannel) error {
+	if len(channels) == 0 {
+		return nil
+	}
+	frame, err := EncodeFrame(server.codecFactory, protoPack)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for _, channel := range channels {
+		if v, ok := channel.(*DefaultChannel); ok && !hasChannelKey(v.codec) {
+			err = v.WriteFrame(frame)
+		} else {
+			err = channel.Write(protoPack)
+		}
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
@@ -1,24 +1,46 @@
 package socket
 
 import (
+	"crypto/tls"
 	"errors"
 	"net"
+	"os"
+	"sync"
 	"time"
 )
 
+/**
+ * 服务端监听接口，ServerSocket 接受TCP 连接，UDPServerSocket 接受UDP 会话
+ * @author abram
+ */
+type IServerSocket interface {
+	Listen() error
+	Accept() (ITransport, error)
+	IsListening() bool
+	Addr() net.Addr
+	Close() error
+	Interrupt() error
+}
+
 type ServerSocket struct {
-	listener      net.Listener
-	addr          net.Addr
-	clientTimeout time.Duration
-	interrupted   bool
+	lock             sync.Mutex // 保护listener 和interrupted，Accept 和Close 在不同的协程中调用
+	listener         net.Listener
+	addr             net.Addr
+	clientTimeout    time.Duration
+	interrupted      bool
+	tlsConfig        *tls.Config    // 不为nil 时接受TLS 连接
+	handshakeTimeout time.Duration  // 等待TLS 握手的时间，0 表示DefaultHandshakeTimeout
+	fileMode         os.FileMode    // unix 套接字文件的权限，0 表示不修改
+	proxy            *proxyProtocol // 不为nil 时解析PROXY 协议头
 }
 
 func NewServerSocket(listenAddr string) (*ServerSocket, error) {
 	return NewServerSocketTimeout(listenAddr, 0)
 }
 
+// listenAddr 为host:port，或者unix:///path、unix://@name 表示unix 套接字
 func NewServerSocketTimeout(listenAddr string, clientTimeout time.Duration) (*ServerSocket, error) {
-	addr, err := net.ResolveTCPAddr("tcp", listenAddr)
+	addr, err := resolveAddr(listenAddr)
 	if err != nil {
 		return nil, err
 	}
@@ -26,60 +48,150 @@ func NewServerSocketTimeout(listenAddr string, clientTimeout time.Duration) (*Se
 	return &ServerSocket{addr: addr, clientTimeout: clientTimeout}, nil
 }
 
+// 根据已经绑定的listener 生成ServerSocket，不需要再调用Listen
+func NewServerSocketFromListener(listener net.Listener, clientTimeout time.Duration) *ServerSocket {
+	return &ServerSocket{listener: listener, addr: listener.Addr(), clientTimeout: clientTimeout}
+}
+
+// 生成一个接受TLS 连接的ServerSocket
+func NewServerSocketTLS(listenAddr string, clientTimeout time.Duration, tlsConfig *tls.Config) (*ServerSocket, error) {
+	serverSocket, err := NewServerSocketTimeout(listenAddr, clientTimeout)
+	if err != nil {
+		return nil, err
+	}
+	serverSocket.tlsConfig = tlsConfig
+	return serverSocket, nil
+}
+
+// 设置等待TLS 握手的时间，0 表示DefaultHandshakeTimeout
+func (serverSocket *ServerSocket) SetHandshakeTimeout(timeout time.Duration) {
+	serverSocket.handshakeTimeout = timeout
+}
+
+// 设置unix 套接字文件的权限，在Listen 之前调用
+func (serverSocket *ServerSocket) SetFileMode(mode os.FileMode) {
+	serverSocket.fileMode = mode
+}
+
+// 接受的连接先解析PROXY 协议头，在Listen 之前调用，nil 表示不解析
+func (serverSocket *ServerSocket) SetProxyProtocol(config *ProxyProtocolConfig) error {
+	if config == nil {
+		serverSocket.proxy = nil
+		return nil
+	}
+	proxy, err := newProxyProtocol(config)
+	if err != nil {
+		return err
+	}
+	serverSocket.proxy = proxy
+	return nil
+}
+
 //判断是否已经在监听了
 func (serverSocket *ServerSocket) IsListening() bool {
-	if serverSocket.listener == nil {
-		return false
-	}
-	return true
+	serverSocket.lock.Lock()
+	defer serverSocket.lock.Unlock()
+	return serverSocket.listener != nil
 }
 
 //开始监听
 func (serverSocket *ServerSocket) Listen() error {
-	if serverSocket.IsListening() {
+	serverSocket.lock.Lock()
+	defer serverSocket.lock.Unlock()
+	if serverSocket.listener != nil {
 		return errors.New("服务已经在监听了。")
 	}
-	l, err := net.Listen(serverSocket.addr.Network(), serverSocket.addr.String())
+	unix := serverSocket.addr.Network() == "unix"
+	if unix {
+		if err := removeStaleUnixSocket(serverSocket.addr.String()); err != nil {
+			return err
+		}
+	}
+	var l net.Listener
+	listen := func() (err error) {
+		l, err = net.Listen(serverSocket.addr.Network(), serverSocket.addr.String())
+		return err
+	}
+	chmod := unix && serverSocket.fileMode != 0 && !isAbstractUnix(serverSocket.addr.String())
+	var err error
+	if chmod {
+		// 创建套接字文件时就用限制的权限，Chmod 之前其它用户没有机会连接
+		err = withUmask(serverSocket.fileMode, listen)
+	} else {
+		err = listen()
+	}
 	if err != nil {
 		return err
 	}
+	// 关闭监听时会自动删除套接字文件
+	if chmod {
+		if err := os.Chmod(serverSocket.addr.String(), serverSocket.fileMode); err != nil {
+			l.Close()
+			return err
+		}
+	}
+	// PROXY 协议头在TLS 握手之前
+	if serverSocket.proxy != nil {
+		l = serverSocket.proxy.listener(l)
+	}
+	if serverSocket.tlsConfig != nil {
+		l = tls.NewListener(l, serverSocket.tlsConfig)
+	}
 	serverSocket.listener = l
+	serverSocket.interrupted = false
 	return nil
 }
 
 //接受客户端的请求
 func (serverSocket *ServerSocket) Accept() (ITransport, error) {
-	if serverSocket.interrupted {
+	// 用局部变量，Accept 阻塞时Close 可以把listener 置为nil
+	serverSocket.lock.Lock()
+	listener, interrupted := serverSocket.listener, serverSocket.interrupted
+	serverSocket.lock.Unlock()
+	if interrupted {
 		return nil, errors.New("Interrupted.")
 	}
-	if serverSocket.listener == nil {
+	if listener == nil {
 		return nil, errors.New("Socket服务没打开。")
 	}
-	conn, err := serverSocket.listener.Accept()
+	conn, err := listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	socket, err := NewSocketFromConnTimeout(conn, serverSocket.clientTimeout)
 	if err != nil {
 		return nil, err
 	}
-	return NewSocketFromConnTimeout(conn, serverSocket.clientTimeout)
+	socket.handshakeTimeout = serverSocket.handshakeTimeout
+	return socket, nil
 }
 
 //获取监听地址
 func (serverSocket *ServerSocket) Addr() net.Addr {
+	serverSocket.lock.Lock()
+	defer serverSocket.lock.Unlock()
+	if listener := serverSocket.listener; listener != nil {
+		return listener.Addr()
+	}
 	return serverSocket.addr
 }
 
 //关闭服务
 func (serverSocket *ServerSocket) Close() error {
-	defer func() {
-		serverSocket.listener = nil
-	}()
-	if serverSocket.IsListening() {
-		serverSocket.listener.Close()
+	serverSocket.lock.Lock()
+	listener := serverSocket.listener
+	serverSocket.listener = nil
+	serverSocket.lock.Unlock()
+	if listener != nil {
+		listener.Close()
 	}
 	return nil
 }
 
-//中断服务
+//中断服务，关闭监听让阻塞中的Accept 返回
 func (serverSocket *ServerSocket) Interrupt() error {
+	serverSocket.lock.Lock()
 	serverSocket.interrupted = true
-	return nil
+	serverSocket.lock.Unlock()
+	return serverSocket.Close()
 }
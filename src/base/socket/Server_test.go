@@ -0,0 +1,241 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestServerShutdown(t *testing.T) {
+	addr := freeAddr(t)
+	received := make(chan struct{})
+	srvConfig := newTestConfig(addr)
+	srvConfig.MessageHandler = func(channel IChannel, protoPack *ProtoPack) {
+		close(received)
+		time.Sleep(100 * time.Millisecond)
+		channel.Write(ProtoPack{Id: protoPack.Id, Body: []byte("done")})
+	}
+	server := startTestServer(t, srvConfig)
+
+	connected := make(chan IChannel, 1)
+	replies := make(chan *ProtoPack, 1)
+	disconnected := make(chan struct{})
+	cliConfig := newTestConfig(addr)
+	cliConfig.ConnectedHandler = func(channel IChannel) { connected <- channel }
+	cliConfig.DisconnectHandler = func(channel IChannel) { close(disconnected) }
+	cliConfig.MessageHandler = func(channel IChannel, protoPack *ProtoPack) { replies <- protoPack }
+	client, err := NewClient(cliConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go client.Open()
+	channel := <-connected
+	if err := channel.Write(ProtoPack{Id: 3}); err != nil {
+		t.Fatal(err)
+	}
+	<-received
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case reply := <-replies:
+		if string(reply.Body) != "done" {
+			t.Fatalf("unexpected reply %+v", reply)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("in-flight reply was not flushed before shutdown")
+	}
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Fatal("client was not disconnected")
+	}
+}
+
+func TestServerBroadcast(t *testing.T) {
+	addr := freeAddr(t)
+	srvConnected := make(chan IChannel, 2)
+	srvConfig := newTestConfig(addr)
+	srvConfig.ConnectedHandler = func(channel IChannel) { srvConnected <- channel }
+	server := startTestServer(t, srvConfig)
+
+	received := make(chan int, 4)
+	for i := 0; i < 2; i++ {
+		i := i
+		cliConfig := newTestConfig(addr)
+		cliConfig.MessageHandler = func(channel IChannel, protoPack *ProtoPack) { received <- i }
+		client, err := NewClient(cliConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go client.Open()
+		defer client.Close()
+	}
+	first, second := <-srvConnected, <-srvConnected
+	first.SetAttribute("platform", byte(1))
+	second.SetAttribute("platform", byte(2))
+
+	if server.ChannelCount() != 2 {
+		t.Fatalf("ChannelCount = %d, want 2", server.ChannelCount())
+	}
+	if v, ok := server.Channel(first.Id()); !ok || v != first {
+		t.Fatal("Channel lookup by id failed")
+	}
+	if n := server.Channels().CountByAttribute("platform", byte(2)); n != 1 {
+		t.Fatalf("CountByAttribute = %d, want 1", n)
+	}
+
+	if err := server.Broadcast(ProtoPack{Id: 1}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-received:
+		case <-time.After(time.Second):
+			t.Fatal("broadcast not received")
+		}
+	}
+
+	if err := server.MulticastByAttribute("platform", byte(2), ProtoPack{Id: 2}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("multicast not received")
+	}
+	select {
+	case <-received:
+		t.Fatal("multicast delivered to wrong channel")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestServeListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	connected := make(chan IChannel, 1)
+	srvConfig := newTestConfig("")
+	srvConfig.ConnectedHandler = func(channel IChannel) { connected <- channel }
+	server, err := NewServer(srvConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	ready := server.Ready()
+	go func() { served <- server.Serve(ctx, listener) }()
+	<-ready
+	if server.Addr().String() != listener.Addr().String() {
+		t.Fatalf("Addr() = %v, want %v", server.Addr(), listener.Addr())
+	}
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	select {
+	case <-connected:
+	case <-time.After(time.Second):
+		t.Fatal("connection not accepted")
+	}
+
+	cancel()
+	select {
+	case err := <-served:
+		if err != context.Canceled {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return after cancel")
+	}
+}
+
+// Accept 先返回可以重试的错误，再返回致命错误
+type flakyListener struct {
+	net.Listener
+	errs []error
+}
+
+func (listener *flakyListener) Accept() (net.Conn, error) {
+	err := listener.errs[0]
+	if len(listener.errs) > 1 {
+		listener.errs = listener.errs[1:]
+	}
+	return nil, err
+}
+
+func TestServeAcceptErrors(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	fatal := errors.New("listener broken")
+	flaky := &flakyListener{Listener: listener, errs: []error{
+		&net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", syscall.EMFILE)},
+		&net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", syscall.ECONNABORTED)},
+		fatal,
+	}}
+	wsAddr := freeAddr(t)
+	srvConfig := newTestConfig("")
+	srvConfig.WebSocketAddr = wsAddr
+	server, err := NewServer(srvConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	start := time.Now()
+	if err := server.Serve(context.Background(), flaky); err != fatal {
+		t.Fatalf("expected fatal error, got %v", err)
+	}
+	// 两次重试分别等5ms 和10ms
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Fatalf("temporary errors were not backed off, took %v", elapsed)
+	}
+	// 致命错误不影响WebSocket 监听
+	conn, err := net.Dial("tcp", wsAddr)
+	if err != nil {
+		t.Fatalf("WebSocket listener closed by accept error: %v", err)
+	}
+	conn.Close()
+}
+
+func TestServerRestart(t *testing.T) {
+	addr := freeAddr(t)
+	connected := make(chan struct{}, 2)
+	srvConfig := newTestConfig(addr)
+	srvConfig.ConnectedHandler = func(channel IChannel) { connected <- struct{}{} }
+	server := startTestServer(t, srvConfig)
+	server.Stop()
+
+	// 停止后可以再次启动
+	ready := server.Ready()
+	go server.Start()
+	select {
+	case <-ready:
+	case <-time.After(time.Second):
+		t.Fatal("server did not restart")
+	}
+	defer server.Stop()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	select {
+	case <-connected:
+	case <-time.After(time.Second):
+		t.Fatal("connection not accepted after restart")
+	}
+}
@@ -0,0 +1,176 @@
+package socket
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+var ErrSnappyCorrupt = errors.New("snappy 数据已损坏。")
+
+const (
+	snappyTagLiteral = 0x00
+	snappyTagCopy1   = 0x01
+	snappyTagCopy2   = 0x02
+	snappyTagCopy4   = 0x03
+
+	snappyHashBits    = 14
+	snappyMaxOffset   = 1 << 16
+	snappyMinMatchLen = 4
+)
+
+/**
+ * snappy 块格式的压缩算法，压缩率不如gzip 但速度快很多，
+ * 和其它实现的snappy 块格式（不是流格式）兼容
+ * @author abram
+ */
+type SnappyCompressor struct {
+}
+
+func (compressor *SnappyCompressor) Compress(src []byte) ([]byte, error) {
+	dst := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(src)+len(src)/6+32)
+	dst = dst[:binary.PutUvarint(dst, uint64(len(src)))]
+
+	var table [1 << snappyHashBits]int32
+	lit := 0 // 还没写出的字面量的起始位置
+	i := 0
+	for i+snappyMinMatchLen <= len(src) {
+		h := snappyHash(binary.LittleEndian.Uint32(src[i:]))
+		candidate := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if candidate < 0 || i-candidate >= snappyMaxOffset ||
+			binary.LittleEndian.Uint32(src[candidate:]) != binary.LittleEndian.Uint32(src[i:]) {
+			i++
+			continue
+		}
+
+		dst = snappyEmitLiteral(dst, src[lit:i])
+		length := snappyMinMatchLen
+		for i+length < len(src) && src[candidate+length] == src[i+length] {
+			length++
+		}
+		dst = snappyEmitCopy(dst, i-candidate, length)
+		i += length
+		lit = i
+	}
+	dst = snappyEmitLiteral(dst, src[lit:])
+	return dst, nil
+}
+
+func (compressor *SnappyCompressor) Decompress(src []byte) ([]byte, error) {
+	return compressor.DecompressLimit(src, math.MaxInt32)
+}
+
+// 解压，解压后的长度超过limit 时不分配内存，直接返回ErrBodyTooLarge
+func (compressor *SnappyCompressor) DecompressLimit(src []byte, limit int) ([]byte, error) {
+	size, n := binary.Uvarint(src)
+	if n <= 0 || size > uint64(len(src))*255+64 {
+		return nil, ErrSnappyCorrupt
+	}
+	if size > uint64(limit) {
+		return nil, &SizeError{Err: ErrBodyTooLarge, Size: int64(size), Limit: limit}
+	}
+	src = src[n:]
+	dst := make([]byte, 0, int(size))
+	for len(src) > 0 {
+		tag := src[0]
+		var length, offset int
+		switch tag & 0x03 {
+		case snappyTagLiteral:
+			length = int(tag >> 2)
+			src = src[1:]
+			if length >= 60 {
+				extra := length - 59
+				if len(src) < extra {
+					return nil, ErrSnappyCorrupt
+				}
+				length = 0
+				for j := extra - 1; j >= 0; j-- {
+					length = length<<8 | int(src[j])
+				}
+				src = src[extra:]
+			}
+			length++
+			if length <= 0 || length > len(src) || len(dst)+length > int(size) {
+				return nil, ErrSnappyCorrupt
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+			continue
+		case snappyTagCopy1:
+			if len(src) < 2 {
+				return nil, ErrSnappyCorrupt
+			}
+			length = 4 + int(tag>>2&0x07)
+			offset = int(tag>>5)<<8 | int(src[1])
+			src = src[2:]
+		case snappyTagCopy2:
+			if len(src) < 3 {
+				return nil, ErrSnappyCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[1:]))
+			src = src[3:]
+		case snappyTagCopy4:
+			if len(src) < 5 {
+				return nil, ErrSnappyCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[1:]))
+			src = src[5:]
+		}
+		if offset <= 0 || offset > len(dst) || len(dst)+length > int(size) {
+			return nil, ErrSnappyCorrupt
+		}
+		// 偏移量可能小于长度，只能逐字节复制
+		start := len(dst) - offset
+		for j := 0; j < length; j++ {
+			dst = append(dst, dst[start+j])
+		}
+	}
+	if len(dst) != int(size) {
+		return nil, ErrSnappyCorrupt
+	}
+	return dst, nil
+}
+
+func snappyHash(v uint32) uint32 {
+	return (v * 0x1e35a7bd) >> (32 - snappyHashBits)
+}
+
+// 写出字面量
+func snappyEmitLiteral(dst, lit []byte) []byte {
+	if len(lit) == 0 {
+		return dst
+	}
+	n := len(lit) - 1
+	switch {
+	case n < 60:
+		dst = append(dst, byte(n)<<2|snappyTagLiteral)
+	case n < 1<<8:
+		dst = append(dst, 60<<2|snappyTagLiteral, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2|snappyTagLiteral, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2|snappyTagLiteral, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2|snappyTagLiteral, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(dst, lit...)
+}
+
+// 写出复制指令，offset 小于64K
+func snappyEmitCopy(dst []byte, offset, length int) []byte {
+	for length >= 68 {
+		dst = append(dst, 63<<2|snappyTagCopy2, byte(offset), byte(offset>>8))
+		length -= 64
+	}
+	if length > 64 {
+		dst = append(dst, 59<<2|snappyTagCopy2, byte(offset), byte(offset>>8))
+		length -= 60
+	}
+	if length >= 12 || offset >= 2048 {
+		return append(dst, byte(length-1)<<2|snappyTagCopy2, byte(offset), byte(offset>>8))
+	}
+	return append(dst, byte(offset>>8)<<5|byte(length-4)<<2|snappyTagCopy1, byte(offset))
+}
@@ -1,16 +1,23 @@
 package socket
 
 import (
+	"crypto/tls"
 	"errors"
 	"net"
+	"sync/atomic"
 	"time"
 )
 
 //socket 结构
 type Socket struct {
-	conn    net.Conn
-	addr    net.Addr
-	timeout time.Duration
+	conn             net.Conn
+	addr             net.Addr
+	timeout          time.Duration
+	readInterrupted  int32         // 读被中断后不再接受新数据，写不受影响
+	closed           int32         // 关闭后conn 不再置为nil，其它协程仍可安全地读到conn
+	tlsConfig        *tls.Config   // 不为nil 时使用TLS 连接
+	handshakeTimeout time.Duration // 服务端等待TLS 握手的时间，0 表示DefaultHandshakeTimeout
+	host             string        // 连接的主机名，用于校验服务端证书
 }
 
 //创建一个客户端的一个socket 连接
@@ -20,16 +27,31 @@ func NewSocket(hostPort string) (*Socket, error) {
 }
 
 //根据hostPort创建一个会超时的socket连接
-//hostPort 格式 host:port
+//hostPort 格式 host:port，或者unix:///path、unix://@name 表示unix 套接字
 //timeout 超时时间
 func NewSocketTimeout(hostPort string, timeout time.Duration) (*Socket, error) {
-	addr, err := net.ResolveTCPAddr("tcp", hostPort)
+	addr, err := resolveAddr(hostPort)
 	if err != nil {
 		return nil, err
 	}
 	return NewSocketFromAddrTimeout(addr, timeout)
 }
 
+// 根据hostPort创建一个TLS 连接，tlsConfig.ServerName 为空时使用hostPort 中的主机名
+func NewSocketTLS(hostPort string, timeout time.Duration, tlsConfig *tls.Config) (*Socket, error) {
+	socket, err := NewSocketTimeout(hostPort, timeout)
+	if err != nil {
+		return nil, err
+	}
+	socket.tlsConfig = tlsConfig
+	if isUnixAddr(hostPort) {
+		// unix 套接字没有主机名，需要在tlsConfig.ServerName 中指定
+		return socket, nil
+	}
+	socket.host, _, err = net.SplitHostPort(hostPort)
+	return socket, err
+}
+
 //根据net.Addr 常见一个socket 连接
 func NewSocketFromAddrTimeout(addr net.Addr, timeout time.Duration) (*Socket, error) {
 	return &Socket{addr: addr, timeout: timeout}, nil
@@ -63,10 +85,7 @@ func (socket *Socket) pushDeadline(read, write bool) {
 
 // 判断客户端socket是否打开
 func (socket *Socket) IsOpen() bool {
-	if socket.conn != nil {
-		return true
-	}
-	return false
+	return socket.conn != nil && atomic.LoadInt32(&socket.closed) == 0
 }
 
 // 打开客户端的socket
@@ -82,46 +101,83 @@ func (socket *Socket) Open() error {
 		return errors.New("网络不好。")
 	}
 
+	var conn net.Conn
 	var err error
-	if socket.conn, err = net.DialTimeout(socket.addr.Network(), socket.addr.String(), socket.timeout); err != nil {
+	if socket.tlsConfig != nil {
+		config := socket.tlsConfig
+		if config.ServerName == "" {
+			config = config.Clone()
+			config.ServerName = socket.host
+		}
+		dialer := &net.Dialer{Timeout: socket.timeout}
+		conn, err = tls.DialWithDialer(dialer, socket.addr.Network(), socket.addr.String(), config)
+	} else {
+		conn, err = net.DialTimeout(socket.addr.Network(), socket.addr.String(), socket.timeout)
+	}
+	if err != nil {
 		return err
 	}
+	socket.conn = conn
+	atomic.StoreInt32(&socket.closed, 0)
 	return nil
 }
 
+// TLS 连接时完成握手，普通连接直接返回nil。
+// 没有设置超时也会使用DefaultHandshakeTimeout，不握手的连接不会一直占用处理协程
+func (socket *Socket) Handshake() error {
+	conn, ok := socket.conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	timeout := socket.handshakeTimeout
+	if timeout <= 0 {
+		timeout = socket.timeout
+	}
+	if timeout <= 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	return conn.SetDeadline(time.Time{})
+}
+
 //获取net.Conn
 func (socket *Socket) Conn() net.Conn {
 	return socket.conn
 }
 
-//关闭连接
+//关闭连接，可以被多个协程同时调用，只有第一次真正关闭
 func (socket *Socket) Close() error {
-	if socket.conn == nil {
+	if socket.conn == nil || !atomic.CompareAndSwapInt32(&socket.closed, 0, 1) {
 		return nil
 	}
-
-	if err := socket.conn.Close(); err != nil {
-		return err
-	}
-	socket.conn = nil
-	return nil
+	return socket.conn.Close()
 }
 
 //读取数据
 func (socket *Socket) Read(buf []byte) (int, error) {
 	if !socket.IsOpen() {
-		return 0, errors.New("Socket 连接已关闭。")
+		return 0, ErrConnectionClosed
 	}
 
 	socket.pushDeadline(true, false)
+	if atomic.LoadInt32(&socket.readInterrupted) == 1 {
+		return 0, errReadInterrupted
+	}
 	n, err := socket.conn.Read(buf)
+	if err != nil && atomic.LoadInt32(&socket.readInterrupted) == 1 {
+		// InterruptRead 设置的超时，不是真的读超时
+		return n, errReadInterrupted
+	}
 	return n, err
 }
 
 //写数据
 func (socket *Socket) Write(buf []byte) (int, error) {
 	if !socket.IsOpen() {
-		return 0, errors.New("Socket 连接已关闭。")
+		return 0, ErrConnectionClosed
 	}
 
 	socket.pushDeadline(false, true)
@@ -137,6 +193,15 @@ func (socket *Socket) Interrupt() error {
 	return socket.conn.Close()
 }
 
+// 中断读操作，正在阻塞的Read 会立即返回，写操作仍然可用
+func (socket *Socket) InterruptRead() error {
+	if !socket.IsOpen() {
+		return nil
+	}
+	atomic.StoreInt32(&socket.readInterrupted, 1)
+	return socket.conn.SetReadDeadline(time.Now())
+}
+
 func (socket *Socket) Flush() error {
 	return nil
 }
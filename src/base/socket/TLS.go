@@ -0,0 +1,130 @@
+package socket
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"time"
+)
+
+// 服务端等待TLS 握手的默认时间，避免不握手的连接一直占用处理协程
+const DefaultHandshakeTimeout = 10 * time.Second
+
+/**
+ * TLS 配置，服务端至少需要证书，客户端不配置证书时只做单向认证
+ * @author abram
+ */
+type TLSConfig struct {
+	CertFile           string             // 证书文件，PEM 格式
+	KeyFile            string             // 私钥文件，PEM 格式
+	Certificates       []tls.Certificate  // 已加载的证书，和CertFile 二选一
+	ClientCAFile       string             // 服务端校验客户端证书用的CA 文件
+	ClientCAs          *x509.CertPool     // 服务端校验客户端证书用的CA
+	ClientAuth         tls.ClientAuthType // 服务端对客户端证书的要求，双向认证用tls.RequireAndVerifyClientCert
+	RootCAFile         string             // 客户端校验服务端证书用的CA 文件
+	RootCAs            *x509.CertPool     // 客户端校验服务端证书用的CA，为nil 时使用系统CA
+	ServerName         string             // 客户端校验的服务端名字，为空时使用Addr 中的主机名
+	InsecureSkipVerify bool               // 客户端不校验服务端证书，只用于测试
+	MinVersion         uint16             // 最低TLS 版本，默认tls.VersionTLS12
+	HandshakeTimeout   time.Duration      // 服务端等待握手完成的时间，0 表示DefaultHandshakeTimeout
+}
+
+/**
+ * 生成一个TLS 配置
+ * @author abram
+ * @param certFile 证书文件
+ * @param keyFile 私钥文件
+ * @return TLSConfig
+ */
+func NewTLSConfig(certFile, keyFile string) *TLSConfig {
+	return &TLSConfig{CertFile: certFile, KeyFile: keyFile}
+}
+
+// 加载证书
+func (config *TLSConfig) certificates() ([]tls.Certificate, error) {
+	certs := config.Certificates
+	if config.CertFile != "" || config.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+func (config *TLSConfig) minVersion() uint16 {
+	if config.MinVersion == 0 {
+		return tls.VersionTLS12
+	}
+	return config.MinVersion
+}
+
+// 加载CA 文件，pool 不为nil 时加到pool 的副本中，不修改调用方的pool
+func loadCertPool(pool *x509.CertPool, caFile string) (*x509.CertPool, error) {
+	if caFile == "" {
+		return pool, nil
+	}
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	if pool == nil {
+		pool = x509.NewCertPool()
+	} else {
+		pool = pool.Clone()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("CA 文件中没有可用的证书：" + caFile)
+	}
+	return pool, nil
+}
+
+/**
+ * 生成服务端使用的tls.Config
+ * @author abram
+ * @return tls.Config
+ */
+func (config *TLSConfig) ServerConfig() (*tls.Config, error) {
+	certs, err := config.certificates()
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("TLS 服务端需要证书。")
+	}
+	clientCAs, err := loadCertPool(config.ClientCAs, config.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: certs,
+		ClientCAs:    clientCAs,
+		ClientAuth:   config.ClientAuth,
+		MinVersion:   config.minVersion(),
+	}, nil
+}
+
+/**
+ * 生成客户端使用的tls.Config
+ * @author abram
+ * @return tls.Config
+ */
+func (config *TLSConfig) ClientConfig() (*tls.Config, error) {
+	certs, err := config.certificates()
+	if err != nil {
+		return nil, err
+	}
+	rootCAs, err := loadCertPool(config.RootCAs, config.RootCAFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates:       certs,
+		RootCAs:            rootCAs,
+		ServerName:         config.ServerName,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+		MinVersion:         config.minVersion(),
+	}, nil
+}
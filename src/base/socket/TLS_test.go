@@ -0,0 +1,264 @@
+package socket
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 生成一个自签名证书，可以同时用作服务端和客户端证书
+func selfSignedCert(t *testing.T, name string) (tls.Certificate, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, cert
+}
+
+func TestMutualTLS(t *testing.T) {
+	serverCert, serverX509 := selfSignedCert(t, "server")
+	clientCert, clientX509 := selfSignedCert(t, "client")
+	serverCAs := x509.NewCertPool()
+	serverCAs.AddCert(serverX509)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientX509)
+
+	addr := freeAddr(t)
+	peers := make(chan string, 1)
+	received := make(chan *ProtoPack, 1)
+	srvConfig := newTestConfig(addr)
+	srvConfig.ConnectedHandler = func(channel IChannel) {
+		certs := channel.PeerCertificates()
+		if len(certs) == 0 {
+			peers <- ""
+			return
+		}
+		peers <- certs[0].Subject.CommonName
+	}
+	srvConfig.MessageHandler = func(channel IChannel, protoPack *ProtoPack) { received <- protoPack }
+	srvConfig.TLS = &TLSConfig{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	startTestServer(t, srvConfig)
+
+	cliConfig := newTestConfig(addr)
+	cliConfig.TLS = &TLSConfig{
+		Certificates: []tls.Certificate{clientCert},
+		RootCAs:      serverCAs,
+	}
+	client, err := NewClient(cliConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if name := <-peers; name != "client" {
+		t.Fatalf("peer certificate = %q, want client", name)
+	}
+	if err := client.Write(ProtoPack{Id: 5, Body: []byte("secret")}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case protoPack := <-received:
+		if string(protoPack.Body) != "secret" {
+			t.Fatalf("unexpected body %q", protoPack.Body)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("message not received over TLS")
+	}
+}
+
+func TestTLSRejectsUnknownClient(t *testing.T) {
+	serverCert, serverX509 := selfSignedCert(t, "server")
+	_, otherX509 := selfSignedCert(t, "other")
+	clientCert, _ := selfSignedCert(t, "client")
+	serverCAs := x509.NewCertPool()
+	serverCAs.AddCert(serverX509)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(otherX509)
+
+	addr := freeAddr(t)
+	connected := make(chan struct{}, 1)
+	srvConfig := newTestConfig(addr)
+	srvConfig.ConnectedHandler = func(channel IChannel) { connected <- struct{}{} }
+	srvConfig.TLS = &TLSConfig{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	startTestServer(t, srvConfig)
+
+	cliConfig := newTestConfig(addr)
+	cliConfig.TLS = &TLSConfig{Certificates: []tls.Certificate{clientCert}, RootCAs: serverCAs}
+	client, err := NewClient(cliConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Open(); err == nil {
+		client.Wait()
+	}
+	select {
+	case <-connected:
+		t.Fatal("server accepted a client with an untrusted certificate")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestTLSHandshakeTimeout(t *testing.T) {
+	serverCert, _ := selfSignedCert(t, "server")
+	addr := freeAddr(t)
+	srvConfig := newTestConfig(addr)
+	srvConfig.TLS = &TLSConfig{Certificates: []tls.Certificate{serverCert}, HandshakeTimeout: 50 * time.Millisecond}
+	server := startTestServer(t, srvConfig)
+	defer server.Stop()
+
+	// 连上之后不握手，服务端超时后关闭连接
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected the connection to be closed")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("server kept the connection open without a handshake")
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	serverCert, serverX509 := selfSignedCert(t, "server")
+
+	// 证书正确但地址错误时NewServer 要返回错误
+	config := newTestConfig("bad address")
+	config.TLS = &TLSConfig{Certificates: []tls.Certificate{serverCert}}
+	if _, err := NewServer(config); err == nil {
+		t.Fatal("expected an error for an invalid address")
+	}
+
+	// CA 文件加到调用方pool 的副本中
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: serverX509.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	tlsConfig, err := (&TLSConfig{RootCAs: pool, RootCAFile: caFile}).ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pool.Equal(x509.NewCertPool()) {
+		t.Fatal("caller's RootCAs was modified")
+	}
+	if tlsConfig.RootCAs.Equal(pool) {
+		t.Fatal("CA file was not loaded")
+	}
+}
+
+func TestServerRestartTLS(t *testing.T) {
+	serverCert, serverX509 := selfSignedCert(t, "server")
+	serverCAs := x509.NewCertPool()
+	serverCAs.AddCert(serverX509)
+
+	addr := freeAddr(t)
+	received := make(chan *ProtoPack, 2)
+	srvConfig := newTestConfig(addr)
+	srvConfig.MessageHandler = func(channel IChannel, protoPack *ProtoPack) { received <- protoPack }
+	srvConfig.TLS = &TLSConfig{Certificates: []tls.Certificate{serverCert}}
+	server, err := NewServer(srvConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 先用Serve 运行一次，取消后再用Start 启动
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	ready := server.Ready()
+	go func() { served <- server.Serve(ctx, listener) }()
+	<-ready
+	cancel()
+	<-served
+	listener.Close()
+
+	ready = server.Ready()
+	go server.Start()
+	select {
+	case <-ready:
+	case <-time.After(time.Second):
+		t.Fatal("server did not restart")
+	}
+	defer server.Stop()
+
+	// 明文连接不能把消息发进来
+	plain, err := NewClient(newTestConfig(addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plain.Open(); err == nil {
+		plain.Write(ProtoPack{Id: 5})
+		defer plain.Close()
+	}
+	select {
+	case protoPack := <-received:
+		t.Fatalf("plaintext message %d delivered to a TLS server", protoPack.Id)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	cliConfig := newTestConfig(addr)
+	cliConfig.TLS = &TLSConfig{RootCAs: serverCAs}
+	client, err := NewClient(cliConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Write(ProtoPack{Id: 6})
+	select {
+	case protoPack := <-received:
+		if protoPack.Id != 6 {
+			t.Fatalf("unexpected message %d", protoPack.Id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("message not received over TLS after restart")
+	}
+}
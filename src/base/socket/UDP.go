@@ -0,0 +1,910 @@
+package socket
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// UDP 数据报的类型
+const (
+	udpData     = 1 // 不可靠的数据，一个数据报是一个完整的数据帧
+	udpReliable = 2 // 可靠有序的数据，带序号，需要确认
+	udpAck      = 3 // 确认，序号为下一个期望收到的序号
+	udpClose    = 4 // 关闭会话
+	udpHello    = 5 // 握手，客户端发出时带cookie 或令牌，服务端发出时带令牌表示会话已建立
+	udpCookie   = 6 // 服务端的握手响应，带和客户端地址绑定的cookie；为空时要求客户端用令牌证明身份
+)
+
+const (
+	udpHeaderSize      = 5     // 类型1 字节+会话id 4 字节
+	udpSeqHeaderSize   = 9     // 再加序号4 字节
+	maxUDPDatagramSize = 65507 // IPv4 下UDP 数据报的最大长度
+	udpCookieSize      = 16    // cookie 和令牌的长度，客户端的hello 和服务端的响应一样长，不会被用来放大流量
+	udpCookieLifetime  = 30    // cookie 的有效期，单位秒，上一个周期的cookie 也有效
+)
+
+var (
+	ErrDatagramTooLarge      = errors.New("数据帧超过UDP 数据报的最大长度。")
+	ErrUDPSessionTimeout     = errors.New("UDP 会话超时。")
+	ErrUDPRetransmitExceeded = errors.New("UDP 重传次数超过限制。")
+	ErrUDPHandshake          = errors.New("UDP 握手失败。")
+)
+
+/**
+ * UDP 传输配置
+ * @author abram
+ */
+type UDPConfig struct {
+	Reliable           bool          // 是否可靠有序传输，false 时一个数据帧一个数据报，可能丢失或乱序
+	SegmentSize        int           // 可靠传输时每个数据报最多携带的数据长度
+	WindowSize         int           // 可靠传输时最多有多少个未确认的数据报，满了之后写会阻塞
+	RetransmitInterval time.Duration // 多久没收到确认就重传
+	MaxRetransmits     int           // 同一个数据报的最大重传次数，超过后关闭会话
+	SessionTimeout     time.Duration // 多久没收到任何数据就关闭会话，需要配合心跳使用
+}
+
+/**
+ * 生成默认的UDP 传输配置
+ * @author abram
+ * @return UDPConfig
+ */
+func NewUDPConfig() *UDPConfig {
+	return &UDPConfig{
+		SegmentSize:        1200,
+		WindowSize:         256,
+		RetransmitInterval: 200 * time.Millisecond,
+		MaxRetransmits:     10,
+		SessionTimeout:     30 * time.Second,
+	}
+}
+
+// 补全没有设置的值
+func (config *UDPConfig) normalize() *UDPConfig {
+	defaults := NewUDPConfig()
+	if config == nil {
+		return defaults
+	}
+	result := *config
+	if result.SegmentSize <= 0 || result.SegmentSize > maxUDPDatagramSize-udpSeqHeaderSize {
+		result.SegmentSize = defaults.SegmentSize
+	}
+	if result.WindowSize <= 0 {
+		result.WindowSize = defaults.WindowSize
+	}
+	if result.RetransmitInterval <= 0 {
+		result.RetransmitInterval = defaults.RetransmitInterval
+	}
+	if result.MaxRetransmits <= 0 {
+		result.MaxRetransmits = defaults.MaxRetransmits
+	}
+	if result.SessionTimeout <= 0 {
+		result.SessionTimeout = defaults.SessionTimeout
+	}
+	return &result
+}
+
+// 生成数据报的头
+func udpPacket(kind byte, id uint32, seq uint32, payload []byte) []byte {
+	size := udpHeaderSize
+	if kind == udpReliable || kind == udpAck {
+		size = udpSeqHeaderSize
+	}
+	packet := make([]byte, size, size+len(payload))
+	packet[0] = kind
+	binary.BigEndian.PutUint32(packet[1:5], id)
+	if size == udpSeqHeaderSize {
+		binary.BigEndian.PutUint32(packet[5:9], seq)
+	}
+	return append(packet, payload...)
+}
+
+// 解析数据报的头
+func parseUDPPacket(packet []byte) (kind byte, id uint32, seq uint32, payload []byte, ok bool) {
+	if len(packet) < udpHeaderSize {
+		return 0, 0, 0, nil, false
+	}
+	kind = packet[0]
+	id = binary.BigEndian.Uint32(packet[1:5])
+	switch kind {
+	case udpData, udpClose, udpHello, udpCookie:
+		return kind, id, 0, packet[udpHeaderSize:], true
+	case udpReliable, udpAck:
+		if len(packet) < udpSeqHeaderSize {
+			return 0, 0, 0, nil, false
+		}
+		return kind, id, binary.BigEndian.Uint32(packet[5:9]), packet[udpSeqHeaderSize:], true
+	}
+	return 0, 0, 0, nil, false
+}
+
+// 等待确认的数据报
+type udpSegment struct {
+	seq     uint32
+	packet  []byte
+	sentAt  time.Time
+	retries int
+}
+
+/**
+ * 一个UDP 会话，实现ITransport。每次Flush 发出缓存的数据，
+ * Read 把收到的数据拼成字节流，所以可以和FramedTransport、DefaultCodec 一起使用
+ * @author abram
+ */
+type udpSession struct {
+	id      uint32
+	config  *UDPConfig
+	send    func(packet []byte) error // 发送一个数据报
+	onClose func()                    // 会话关闭后调用
+
+	writeLock   sync.Mutex
+	writeBuffer bytes.Buffer
+
+	lock            sync.Mutex
+	remote          net.Addr // 只在收到带令牌的hello 时改变，伪造的数据报不能把会话转走
+	token           []byte   // 握手时服务端生成的令牌，地址变化时用来证明身份
+	queue           [][]byte // 已经可以读取的数据
+	current         []byte
+	notify          chan struct{}
+	done            chan struct{}
+	closed          bool
+	err             error // 关闭的原因
+	readInterrupted bool
+	lastRecv        time.Time
+
+	nextSeq    uint32
+	unacked    []*udpSegment
+	windowFree chan struct{}
+	expected   uint32
+	pending    map[uint32][]byte // 乱序到达的数据报
+}
+
+func newUDPSession(id uint32, config *UDPConfig, send func(packet []byte) error, onClose func()) *udpSession {
+	session := &udpSession{
+		id:         id,
+		config:     config,
+		send:       send,
+		onClose:    onClose,
+		notify:     make(chan struct{}, 1),
+		done:       make(chan struct{}),
+		windowFree: make(chan struct{}, 1),
+		pending:    make(map[uint32][]byte),
+		lastRecv:   time.Now(),
+	}
+	go session.loop()
+	return session
+}
+
+func wakeup(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// 重传和超时检查
+func (session *udpSession) loop() {
+	ticker := time.NewTicker(session.config.RetransmitInterval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-session.done:
+			return
+		case now := <-ticker.C:
+			var resend [][]byte
+			var err error
+			session.lock.Lock()
+			if now.Sub(session.lastRecv) > session.config.SessionTimeout {
+				err = ErrUDPSessionTimeout
+			}
+			for _, segment := range session.unacked {
+				if err != nil {
+					break
+				}
+				if now.Sub(segment.sentAt) < session.config.RetransmitInterval {
+					continue
+				}
+				if segment.retries >= session.config.MaxRetransmits {
+					err = ErrUDPRetransmitExceeded
+					break
+				}
+				segment.retries++
+				segment.sentAt = now
+				resend = append(resend, segment.packet)
+			}
+			session.lock.Unlock()
+			if err != nil {
+				session.closeWith(err)
+				return
+			}
+			for _, packet := range resend {
+				session.send(packet)
+			}
+		}
+	}
+}
+
+// 收到一个属于本会话的数据报，调用前已经确认了来源
+func (session *udpSession) receive(kind byte, seq uint32, payload []byte) {
+	var ack []byte
+	session.lock.Lock()
+	if session.closed {
+		session.lock.Unlock()
+		return
+	}
+	session.lastRecv = time.Now()
+	queueLimit := session.config.WindowSize * 4
+	switch kind {
+	case udpData:
+		if len(payload) > 0 && len(session.queue) < queueLimit {
+			session.queue = append(session.queue, append([]byte(nil), payload...))
+			wakeup(session.notify)
+		}
+	case udpReliable:
+		offset := int32(seq - session.expected)
+		switch {
+		case offset < 0:
+			// 重复的数据报，对方没收到确认
+		case offset >= int32(session.config.WindowSize):
+			session.lock.Unlock()
+			return
+		case offset == 0:
+			// 读得太慢时不确认，让对方稍后重传
+			if len(session.queue) >= queueLimit {
+				session.lock.Unlock()
+				return
+			}
+			session.queue = append(session.queue, append([]byte(nil), payload...))
+			session.expected++
+			for {
+				data, ok := session.pending[session.expected]
+				if !ok {
+					break
+				}
+				delete(session.pending, session.expected)
+				session.queue = append(session.queue, data)
+				session.expected++
+			}
+			wakeup(session.notify)
+		default:
+			session.pending[seq] = append([]byte(nil), payload...)
+		}
+		ack = udpPacket(udpAck, session.id, session.expected, nil)
+	case udpAck:
+		i := 0
+		for ; i < len(session.unacked); i++ {
+			if int32(session.unacked[i].seq-seq) >= 0 {
+				break
+			}
+		}
+		if i > 0 {
+			session.unacked = session.unacked[i:]
+			wakeup(session.windowFree)
+		}
+	case udpClose:
+		session.lock.Unlock()
+		session.closeWith(io.EOF)
+		return
+	}
+	session.lock.Unlock()
+	if ack != nil {
+		session.send(ack)
+	}
+}
+
+// 关闭会话，只有第一次调用有效
+func (session *udpSession) closeWith(err error) bool {
+	session.lock.Lock()
+	if session.closed {
+		session.lock.Unlock()
+		return false
+	}
+	session.closed = true
+	session.err = err
+	close(session.done)
+	session.lock.Unlock()
+	if session.onClose != nil {
+		session.onClose()
+	}
+	return true
+}
+
+/**
+ * 检查数据报是否来自会话的地址，不是时只有带令牌的hello 可以把会话转到新地址，
+ * 比如客户端在NAT 后面换了端口
+ * @author abram
+ * @param kind 数据报类型
+ * @param payload 数据
+ * @param from 数据报的来源
+ * @return bool
+ */
+func (session *udpSession) verify(kind byte, payload []byte, from net.Addr) bool {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+	if session.remote != nil && session.remote.String() == from.String() {
+		return true
+	}
+	if kind != udpHello || subtle.ConstantTimeCompare(payload, session.token) != 1 {
+		return false
+	}
+	session.remote = from
+	return true
+}
+
+// 对方的地址
+func (session *udpSession) RemoteAddr() net.Addr {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+	return session.remote
+}
+
+func (session *udpSession) Read(buf []byte) (int, error) {
+	for {
+		session.lock.Lock()
+		if len(session.current) == 0 && len(session.queue) > 0 {
+			session.current = session.queue[0]
+			session.queue[0] = nil
+			session.queue = session.queue[1:]
+		}
+		if len(session.current) > 0 {
+			n := copy(buf, session.current)
+			session.current = session.current[n:]
+			session.lock.Unlock()
+			return n, nil
+		}
+		if session.readInterrupted {
+			session.lock.Unlock()
+			return 0, errors.New("UDP 读已中断。")
+		}
+		if session.closed {
+			err := session.err
+			session.lock.Unlock()
+			return 0, err
+		}
+		session.lock.Unlock()
+
+		select {
+		case <-session.notify:
+		case <-session.done:
+		}
+	}
+}
+
+// 写入缓存，Flush 时发出
+func (session *udpSession) Write(buf []byte) (int, error) {
+	if !session.IsOpen() {
+		return 0, ErrChannelClosed
+	}
+	session.writeLock.Lock()
+	defer session.writeLock.Unlock()
+	return session.writeBuffer.Write(buf)
+}
+
+func (session *udpSession) Flush() error {
+	session.writeLock.Lock()
+	defer session.writeLock.Unlock()
+	data := session.writeBuffer.Bytes()
+	defer session.writeBuffer.Reset()
+	if len(data) == 0 {
+		return nil
+	}
+	if !session.IsOpen() {
+		return ErrChannelClosed
+	}
+
+	if !session.config.Reliable {
+		if len(data) > maxUDPDatagramSize-udpHeaderSize {
+			return &SizeError{Err: ErrDatagramTooLarge, Size: int64(len(data)), Limit: maxUDPDatagramSize - udpHeaderSize}
+		}
+		return session.send(udpPacket(udpData, session.id, 0, data))
+	}
+
+	for len(data) > 0 {
+		n := len(data)
+		if n > session.config.SegmentSize {
+			n = session.config.SegmentSize
+		}
+		packet, err := session.enqueue(data[:n])
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+		if err := session.send(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 等发送窗口有空位后分配序号，放入待确认列表
+func (session *udpSession) enqueue(data []byte) ([]byte, error) {
+	for {
+		session.lock.Lock()
+		if session.closed {
+			err := session.err
+			session.lock.Unlock()
+			return nil, err
+		}
+		if len(session.unacked) < session.config.WindowSize {
+			packet := udpPacket(udpReliable, session.id, session.nextSeq, data)
+			session.unacked = append(session.unacked, &udpSegment{seq: session.nextSeq, packet: packet, sentAt: time.Now()})
+			session.nextSeq++
+			session.lock.Unlock()
+			return packet, nil
+		}
+		session.lock.Unlock()
+
+		select {
+		case <-session.windowFree:
+		case <-session.done:
+		}
+	}
+}
+
+// 通知对方后关闭会话
+func (session *udpSession) Close() error {
+	if !session.IsOpen() {
+		return nil
+	}
+	session.send(udpPacket(udpClose, session.id, 0, nil))
+	session.closeWith(ErrChannelClosed)
+	return nil
+}
+
+func (session *udpSession) Open() error {
+	return nil
+}
+
+func (session *udpSession) IsOpen() bool {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+	return !session.closed
+}
+
+func (session *udpSession) Peek() bool {
+	return session.IsOpen()
+}
+
+// 不可靠传输时一个数据报只能放一个数据帧，写队列不能合并写
+func (session *udpSession) framePerWrite() bool {
+	return !session.config.Reliable
+}
+
+// 中断读操作，正在阻塞的Read 会立即返回，写操作仍然可用
+func (session *udpSession) InterruptRead() error {
+	session.lock.Lock()
+	session.readInterrupted = true
+	session.lock.Unlock()
+	wakeup(session.notify)
+	return nil
+}
+
+/**
+ * 客户端的UDP 连接，Open 时生成随机的会话id 并和服务端握手：
+ * 服务端先回复和客户端地址绑定的cookie，客户端带上cookie 再发一次hello 后服务端才建立会话并返回令牌，
+ * 伪造源地址的数据报不会建立会话。之后服务端只接受会话地址发来的数据报，地址变化时客户端用令牌证明身份
+ * @author abram
+ */
+type UDPSocket struct {
+	*udpSession
+	addr    string
+	timeout time.Duration
+	config  *UDPConfig
+	conn    net.Conn
+}
+
+/**
+ * 生成一个客户端UDP 连接，调用Open 后才可以使用
+ * @author abram
+ * @param hostPort 服务端地址
+ * @param timeout 连接超时时间
+ * @param config UDP 传输配置，nil 时使用默认值
+ * @return UDPSocket
+ */
+func NewUDPSocket(hostPort string, timeout time.Duration, config *UDPConfig) (*UDPSocket, error) {
+	if _, err := net.ResolveUDPAddr("udp", hostPort); err != nil {
+		return nil, err
+	}
+	return &UDPSocket{addr: hostPort, timeout: timeout, config: config.normalize()}, nil
+}
+
+// 和服务端握手建立会话，握手失败时返回ErrUDPHandshake
+func (socket *UDPSocket) Open() error {
+	if socket.IsOpen() {
+		return nil
+	}
+	conn, err := net.DialTimeout("udp", socket.addr, socket.timeout)
+	if err != nil {
+		return err
+	}
+	var id [4]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		conn.Close()
+		return err
+	}
+	token, err := udpHandshake(conn, binary.BigEndian.Uint32(id[:]), socket.config)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	send := func(packet []byte) error {
+		_, err := conn.Write(packet)
+		return err
+	}
+	session := newUDPSession(binary.BigEndian.Uint32(id[:]), socket.config, send, func() { conn.Close() })
+	session.remote = conn.RemoteAddr()
+	session.token = token
+	socket.conn = conn
+	socket.udpSession = session
+	go socket.readLoop(conn, session)
+	return nil
+}
+
+/**
+ * 客户端握手：先发送空cookie 的hello，收到服务端的cookie 后带上cookie 再发送，
+ * 收到带令牌的hello 后会话建立。没有响应时按RetransmitInterval 重发，最多MaxRetransmits 次
+ * @author abram
+ * @param conn 连接服务端的socket
+ * @param id 会话id
+ * @param config UDP 传输配置
+ * @return 令牌
+ */
+func udpHandshake(conn net.Conn, id uint32, config *UDPConfig) ([]byte, error) {
+	cookie := make([]byte, udpCookieSize)
+	buf := make([]byte, maxUDPDatagramSize)
+	defer conn.SetReadDeadline(time.Time{})
+	for retries := 0; retries <= config.MaxRetransmits; retries++ {
+		if _, err := conn.Write(udpPacket(udpHello, id, 0, cookie)); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(config.RetransmitInterval))
+		for {
+			n, err := conn.Read(buf)
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			kind, pid, _, payload, ok := parseUDPPacket(buf[:n])
+			if !ok || pid != id || len(payload) != udpCookieSize {
+				continue
+			}
+			if kind == udpHello {
+				return append([]byte(nil), payload...), nil
+			}
+			if kind == udpCookie {
+				copy(cookie, payload)
+				break
+			}
+		}
+	}
+	return nil, ErrUDPHandshake
+}
+
+// 读取服务端发来的数据报
+func (socket *UDPSocket) readLoop(conn net.Conn, session *udpSession) {
+	buf := make([]byte, maxUDPDatagramSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			session.closeWith(err)
+			return
+		}
+		kind, id, seq, payload, ok := parseUDPPacket(buf[:n])
+		if !ok || id != session.id {
+			continue
+		}
+		switch kind {
+		case udpCookie:
+			// 服务端从别的地址收到了这个会话的数据报，比如NAT 换了端口，用令牌证明身份
+			session.send(udpPacket(udpHello, session.id, 0, session.token))
+		case udpHello:
+			// 重复的握手响应
+		default:
+			session.receive(kind, seq, payload)
+		}
+	}
+}
+
+func (socket *UDPSocket) IsOpen() bool {
+	return socket.udpSession != nil && socket.udpSession.IsOpen()
+}
+
+func (socket *UDPSocket) Peek() bool {
+	return socket.IsOpen()
+}
+
+func (socket *UDPSocket) Read(buf []byte) (int, error) {
+	if socket.udpSession == nil {
+		return 0, ErrChannelClosed
+	}
+	return socket.udpSession.Read(buf)
+}
+
+func (socket *UDPSocket) Write(buf []byte) (int, error) {
+	if socket.udpSession == nil {
+		return 0, ErrChannelClosed
+	}
+	return socket.udpSession.Write(buf)
+}
+
+func (socket *UDPSocket) Flush() error {
+	if socket.udpSession == nil {
+		return ErrChannelClosed
+	}
+	return socket.udpSession.Flush()
+}
+
+func (socket *UDPSocket) framePerWrite() bool {
+	return socket.udpSession != nil && socket.udpSession.framePerWrite()
+}
+
+func (socket *UDPSocket) Close() error {
+	if socket.udpSession == nil {
+		return nil
+	}
+	return socket.udpSession.Close()
+}
+
+func (socket *UDPSocket) InterruptRead() error {
+	if socket.udpSession == nil {
+		return nil
+	}
+	return socket.udpSession.InterruptRead()
+}
+
+/**
+ * 服务端的UDP 监听，按会话id 把数据报分给不同的会话，
+ * 每个新会话通过Accept 返回，和TCP 连接一样处理
+ * @author abram
+ */
+type UDPServerSocket struct {
+	addr   *net.UDPAddr
+	config *UDPConfig
+	lock   sync.Mutex // 保护listen 和所有udpListen 的字段
+	listen *udpListen // 当前的监听，Interrupt 后为nil，可以重新Listen
+}
+
+// 一次Listen 的状态。Interrupt 后已有的会话继续使用这个socket，全部关闭后才释放
+type udpListen struct {
+	conn        *net.UDPConn
+	secret      []byte // 生成cookie 的密钥，每次Listen 时随机生成
+	sessions    map[uint32]*udpSession
+	accepted    chan ITransport
+	done        chan struct{}
+	interrupted bool
+}
+
+/**
+ * 生成UDP 监听
+ * @author abram
+ * @param listenAddr 监听地址
+ * @param config UDP 传输配置，nil 时使用默认值
+ * @return UDPServerSocket
+ */
+func NewUDPServerSocket(listenAddr string, config *UDPConfig) (*UDPServerSocket, error) {
+	addr, err := net.ResolveUDPAddr("udp", listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &UDPServerSocket{addr: addr, config: config.normalize()}, nil
+}
+
+// 判断是否已经在监听了
+func (serverSocket *UDPServerSocket) IsListening() bool {
+	serverSocket.lock.Lock()
+	defer serverSocket.lock.Unlock()
+	return serverSocket.listen != nil
+}
+
+// 开始监听，Interrupt 之后可以再次调用，上一次的会话还没关闭时端口可能仍被占用
+func (serverSocket *UDPServerSocket) Listen() error {
+	if serverSocket.IsListening() {
+		return errors.New("服务已经在监听了。")
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", serverSocket.addr)
+	if err != nil {
+		return err
+	}
+	listen := &udpListen{
+		conn:     conn,
+		secret:   secret,
+		sessions: make(map[uint32]*udpSession),
+		accepted: make(chan ITransport, 128),
+		done:     make(chan struct{}),
+	}
+	serverSocket.lock.Lock()
+	serverSocket.listen = listen
+	serverSocket.lock.Unlock()
+	go serverSocket.readLoop(listen)
+	return nil
+}
+
+// 读取数据报并分给对应的会话，没有会话时只处理握手
+func (serverSocket *UDPServerSocket) readLoop(listen *udpListen) {
+	conn := listen.conn
+	buf := make([]byte, maxUDPDatagramSize)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			serverSocket.closeSessions(listen, err)
+			return
+		}
+		kind, id, seq, payload, ok := parseUDPPacket(buf[:n])
+		if !ok {
+			continue
+		}
+		serverSocket.lock.Lock()
+		session := listen.sessions[id]
+		serverSocket.lock.Unlock()
+		if session == nil {
+			if kind == udpHello && len(payload) == udpCookieSize {
+				serverSocket.handshake(listen, id, payload, from)
+			}
+			continue
+		}
+		if !session.verify(kind, payload, from) {
+			// 不是会话的地址，要求对方用令牌证明身份，响应比请求短
+			conn.WriteToUDP(udpPacket(udpCookie, id, 0, nil), from)
+			continue
+		}
+		if kind == udpHello {
+			// 客户端没收到握手响应或者换了地址
+			session.send(udpPacket(udpHello, id, 0, session.token))
+			continue
+		}
+		session.receive(kind, seq, payload)
+	}
+}
+
+// 和地址、会话id 绑定的cookie，只有能收到响应的地址才能拿到
+func udpCookieFor(secret []byte, epoch int64, id uint32, from *net.UDPAddr) []byte {
+	mac := hmac.New(sha256.New, secret)
+	var buf [12]byte
+	binary.BigEndian.PutUint64(buf[:8], uint64(epoch))
+	binary.BigEndian.PutUint32(buf[8:], id)
+	mac.Write(buf[:])
+	mac.Write([]byte(from.String()))
+	return mac.Sum(nil)[:udpCookieSize]
+}
+
+// 处理新会话的hello：cookie 不对时回复cookie，对时建立会话并回复令牌
+func (serverSocket *UDPServerSocket) handshake(listen *udpListen, id uint32, cookie []byte, from *net.UDPAddr) {
+	conn, secret := listen.conn, listen.secret
+	epoch := time.Now().Unix() / udpCookieLifetime
+	if !hmac.Equal(cookie, udpCookieFor(secret, epoch, id, from)) && !hmac.Equal(cookie, udpCookieFor(secret, epoch-1, id, from)) {
+		conn.WriteToUDP(udpPacket(udpCookie, id, 0, udpCookieFor(secret, epoch, id, from)), from)
+		return
+	}
+	token := make([]byte, udpCookieSize)
+	if _, err := rand.Read(token); err != nil {
+		return
+	}
+
+	serverSocket.lock.Lock()
+	if listen.interrupted || listen.sessions[id] != nil {
+		serverSocket.lock.Unlock()
+		return
+	}
+	session := serverSocket.newSession(listen, id, from)
+	session.token = token
+	select {
+	case listen.accepted <- session:
+	default:
+		// 来不及Accept 的会话直接丢弃
+		delete(listen.sessions, id)
+		serverSocket.lock.Unlock()
+		session.closeWith(ErrChannelClosed)
+		return
+	}
+	serverSocket.lock.Unlock()
+	session.send(udpPacket(udpHello, id, 0, token))
+}
+
+// 生成新会话，调用前要持有lock
+func (serverSocket *UDPServerSocket) newSession(listen *udpListen, id uint32, from net.Addr) *udpSession {
+	var session *udpSession
+	send := func(packet []byte) error {
+		_, err := listen.conn.WriteTo(packet, session.RemoteAddr())
+		return err
+	}
+	session = newUDPSession(id, serverSocket.config, send, func() {
+		serverSocket.lock.Lock()
+		defer serverSocket.lock.Unlock()
+		if listen.sessions[id] == session {
+			delete(listen.sessions, id)
+		}
+		// 停止监听后等所有会话都关闭了再关闭socket
+		if listen.interrupted && len(listen.sessions) == 0 {
+			listen.conn.Close()
+		}
+	})
+	session.remote = from
+	listen.sessions[id] = session
+	return session
+}
+
+// socket 出错时关闭所有会话
+func (serverSocket *UDPServerSocket) closeSessions(listen *udpListen, err error) {
+	serverSocket.lock.Lock()
+	sessions := make([]*udpSession, 0, len(listen.sessions))
+	for _, session := range listen.sessions {
+		sessions = append(sessions, session)
+	}
+	serverSocket.lock.Unlock()
+	for _, session := range sessions {
+		session.closeWith(err)
+	}
+}
+
+// 接受新的会话
+func (serverSocket *UDPServerSocket) Accept() (ITransport, error) {
+	serverSocket.lock.Lock()
+	listen := serverSocket.listen
+	serverSocket.lock.Unlock()
+	if listen == nil {
+		return nil, errors.New("Socket服务没打开。")
+	}
+	select {
+	case session := <-listen.accepted:
+		return session, nil
+	case <-listen.done:
+		return nil, errors.New("Interrupted.")
+	}
+}
+
+// 获取监听地址
+func (serverSocket *UDPServerSocket) Addr() net.Addr {
+	serverSocket.lock.Lock()
+	defer serverSocket.lock.Unlock()
+	if serverSocket.listen != nil {
+		return serverSocket.listen.conn.LocalAddr()
+	}
+	return serverSocket.addr
+}
+
+// 关闭监听和所有会话
+func (serverSocket *UDPServerSocket) Close() error {
+	listen := serverSocket.interrupt()
+	if listen == nil {
+		return nil
+	}
+	// socket 关闭后读协程会关闭所有会话
+	return listen.conn.Close()
+}
+
+// 不再接受新的会话，已有的会话继续工作，全部关闭后释放socket，之后可以重新Listen
+func (serverSocket *UDPServerSocket) Interrupt() error {
+	serverSocket.interrupt()
+	return nil
+}
+
+// 停止当前的监听，返回被停止的监听，没有在监听时返回nil
+func (serverSocket *UDPServerSocket) interrupt() *udpListen {
+	serverSocket.lock.Lock()
+	defer serverSocket.lock.Unlock()
+	listen := serverSocket.listen
+	if listen == nil {
+		return nil
+	}
+	serverSocket.listen = nil
+	listen.interrupted = true
+	close(listen.done)
+	if len(listen.sessions) == 0 {
+		listen.conn.Close()
+	}
+	return listen
+}
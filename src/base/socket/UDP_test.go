@@ -0,0 +1,224 @@
+package socket
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUDPEcho(t *testing.T) {
+	for _, reliable := range []bool{false, true} {
+		addr := freeAddr(t)
+		udpConfig := NewUDPConfig()
+		udpConfig.Reliable = reliable
+		srvConfig := newTestConfig(addr)
+		srvConfig.Network = "udp"
+		srvConfig.UDP = udpConfig
+		srvConfig.MessageHandler = func(channel IChannel, protoPack *ProtoPack) {
+			channel.Write(ProtoPack{Id: protoPack.Id, Body: protoPack.Body})
+		}
+		server := startTestServer(t, srvConfig)
+
+		received := make(chan *ProtoPack, 1)
+		cliConfig := newTestConfig(addr)
+		cliConfig.Network = "udp"
+		cliConfig.UDP = udpConfig
+		cliConfig.MessageHandler = func(channel IChannel, protoPack *ProtoPack) { received <- protoPack }
+		client, err := NewClient(cliConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Open(); err != nil {
+			t.Fatal(err)
+		}
+
+		// 可靠传输时超过SegmentSize，要分成多个数据报
+		body := bytes.Repeat([]byte{7}, 5000)
+		if err := client.Write(ProtoPack{Id: 5, Body: body}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case protoPack := <-received:
+			if protoPack.Id != 5 || !bytes.Equal(protoPack.Body, body) {
+				t.Fatalf("reliable=%v: unexpected pack id=%d len=%d", reliable, protoPack.Id, len(protoPack.Body))
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("reliable=%v: no reply over UDP", reliable)
+		}
+		client.Close()
+		server.Stop()
+	}
+}
+
+func TestUDPReliableLoss(t *testing.T) {
+	config := NewUDPConfig()
+	config.Reliable = true
+	config.SegmentSize = 16
+	config.RetransmitInterval = 20 * time.Millisecond
+
+	var sender, receiver *udpSession
+	var count int32
+	// 每3 个数据报丢一个，确认也会丢
+	lossy := func(to **udpSession) func(packet []byte) error {
+		return func(packet []byte) error {
+			if atomic.AddInt32(&count, 1)%3 == 0 {
+				return nil
+			}
+			kind, _, seq, payload, _ := parseUDPPacket(packet)
+			go (*to).receive(kind, seq, payload)
+			return nil
+		}
+	}
+	sender = newUDPSession(1, config, lossy(&receiver), nil)
+	receiver = newUDPSession(1, config, lossy(&sender), nil)
+	defer sender.Close()
+	defer receiver.Close()
+
+	var want bytes.Buffer
+	for i := 0; i < 50; i++ {
+		data := bytes.Repeat([]byte{byte(i)}, 1+i%40)
+		want.Write(data)
+		sender.Write(data)
+		if err := sender.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := make([]byte, want.Len())
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(receiver, got)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil || !bytes.Equal(got, want.Bytes()) {
+			t.Fatalf("stream mismatch, err=%v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reliable stream not delivered")
+	}
+}
+
+func TestUDPHandshake(t *testing.T) {
+	addr := freeAddr(t)
+	connected := make(chan struct{}, 4)
+	received := make(chan int16, 4)
+	srvConfig := newTestConfig(addr)
+	srvConfig.Network = "udp"
+	srvConfig.ConnectedHandler = func(channel IChannel) { connected <- struct{}{} }
+	srvConfig.MessageHandler = func(channel IChannel, protoPack *ProtoPack) { received <- protoPack.Id }
+	server := startTestServer(t, srvConfig)
+	defer server.Stop()
+
+	dial := func() *net.UDPConn {
+		conn, err := net.Dial("udp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn.(*net.UDPConn)
+	}
+	// 等一个指定类型的响应
+	expect := func(conn *net.UDPConn, want byte) []byte {
+		buf := make([]byte, maxUDPDatagramSize)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kind, _, _, payload, ok := parseUDPPacket(buf[:n])
+		if !ok || kind != want {
+			t.Fatalf("expected packet type %d, got %d", want, kind)
+		}
+		return append([]byte(nil), payload...)
+	}
+	frame, _ := EncodeFrame(NewDefaultCodecFactory(), ProtoPack{Id: 7})
+	data := append([]byte{0, 0, 0, byte(len(frame))}, frame...)
+
+	// 没有握手的数据报不会建立会话
+	conn := dial()
+	defer conn.Close()
+	conn.Write(udpPacket(udpData, 1, 0, data))
+	conn.Write(udpPacket(udpHello, 1, 0, make([]byte, udpCookieSize)))
+	cookie := expect(conn, udpCookie)
+	select {
+	case <-connected:
+		t.Fatal("session created without a cookie")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// 带上cookie 后建立会话
+	conn.Write(udpPacket(udpHello, 1, 0, cookie))
+	token := expect(conn, udpHello)
+	<-connected
+
+	// 别的地址不能用会话id 发数据
+	other := dial()
+	defer other.Close()
+	other.Write(udpPacket(udpData, 1, 0, data))
+	expect(other, udpCookie)
+	select {
+	case <-received:
+		t.Fatal("packet from another address accepted")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// 带令牌后会话转到新地址
+	other.Write(udpPacket(udpHello, 1, 0, token))
+	expect(other, udpHello)
+	other.Write(udpPacket(udpData, 1, 0, data))
+	select {
+	case id := <-received:
+		if id != 7 {
+			t.Fatalf("unexpected id %d", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("packet after migration not delivered")
+	}
+}
+
+func TestUDPServerRestart(t *testing.T) {
+	addr := freeAddr(t)
+	srvConfig := newTestConfig(addr)
+	srvConfig.Network = "udp"
+	srvConfig.MessageHandler = func(channel IChannel, protoPack *ProtoPack) {
+		channel.Write(ProtoPack{Id: protoPack.Id})
+	}
+	server := startTestServer(t, srvConfig)
+	server.Stop()
+
+	// 停止后可以再次启动
+	ready := server.Ready()
+	go server.Start()
+	select {
+	case <-ready:
+	case <-time.After(time.Second):
+		t.Fatal("UDP server did not restart")
+	}
+	defer server.Stop()
+
+	received := make(chan *ProtoPack, 1)
+	cliConfig := newTestConfig(addr)
+	cliConfig.Network = "udp"
+	cliConfig.MessageHandler = func(channel IChannel, protoPack *ProtoPack) { received <- protoPack }
+	client, err := NewClient(cliConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Write(ProtoPack{Id: 9})
+	select {
+	case protoPack := <-received:
+		if protoPack.Id != 9 {
+			t.Fatalf("unexpected reply %d", protoPack.Id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no reply over UDP after restart")
+	}
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+
+package socket
+
+import (
+	"os"
+	"sync"
+	"syscall"
+)
+
+// umask 是进程级的，同时只能有一个协程修改
+var umaskLock sync.Mutex
+
+/**
+ * 在收紧的umask 下执行fn，fn 新建的文件权限不会超过mode，
+ * 用于监听unix 套接字，套接字文件不会短暂地带着更宽的权限。
+ * 期间其它协程新建的文件权限只会更严，不会更宽
+ * @author abram
+ * @param mode 允许的最大权限
+ * @param fn 新建文件的操作
+ */
+func withUmask(mode os.FileMode, fn func() error) error {
+	umaskLock.Lock()
+	defer umaskLock.Unlock()
+	// 读umask 只能先设置一个值，先设成最严的
+	old := syscall.Umask(0777)
+	syscall.Umask(old | int(0777&^mode.Perm()))
+	defer syscall.Umask(old)
+	return fn()
+}
@@ -0,0 +1,8 @@
+package socket
+
+import "os"
+
+// windows 没有umask，套接字文件的权限在创建后由Chmod 设置
+func withUmask(mode os.FileMode, fn func() error) error {
+	return fn()
+}
@@ -0,0 +1,45 @@
+package socket
+
+import (
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const unixScheme = "unix://"
+
+// 是否为unix 套接字地址，比如unix:///var/run/app.sock 或unix://@app（抽象套接字）
+func isUnixAddr(addr string) bool {
+	return strings.HasPrefix(addr, unixScheme)
+}
+
+// 解析监听或连接地址，unix:// 开头的是unix 套接字，其他的是TCP 地址
+func resolveAddr(addr string) (net.Addr, error) {
+	if isUnixAddr(addr) {
+		return net.ResolveUnixAddr("unix", strings.TrimPrefix(addr, unixScheme))
+	}
+	return net.ResolveTCPAddr("tcp", addr)
+}
+
+// 是否为抽象套接字，抽象套接字没有对应的文件
+func isAbstractUnix(path string) bool {
+	return path == "" || path[0] == '@'
+}
+
+// 删除上次进程没有清理的套接字文件，文件还在被监听时不删除
+func removeStaleUnixSocket(path string) error {
+	if isAbstractUnix(path) {
+		return nil
+	}
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+	conn, err := net.DialTimeout("unix", path, 100*time.Millisecond)
+	if err == nil {
+		conn.Close()
+		return nil
+	}
+	return os.Remove(path)
+}
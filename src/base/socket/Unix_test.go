@@ -0,0 +1,87 @@
+package socket
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.sock")
+	// 上次进程没有清理的套接字文件
+	stale, err := NewServerSocket("unix://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stale.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	stale.listener.(interface{ SetUnlinkOnClose(bool) }).SetUnlinkOnClose(false)
+	stale.Close()
+
+	srvConfig := newTestConfig("unix://" + path)
+	srvConfig.UnixSocketMode = 0600
+	srvConfig.MessageHandler = func(channel IChannel, protoPack *ProtoPack) {
+		channel.Write(ProtoPack{Id: protoPack.Id})
+	}
+	server := startTestServer(t, srvConfig)
+
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("unexpected socket file %v, %v", info, err)
+	}
+
+	received := make(chan *ProtoPack, 1)
+	cliConfig := newTestConfig("unix://" + path)
+	cliConfig.MessageHandler = func(channel IChannel, protoPack *ProtoPack) { received <- protoPack }
+	client, err := NewClient(cliConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Write(ProtoPack{Id: 4}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case protoPack := <-received:
+		if protoPack.Id != 4 {
+			t.Fatalf("unexpected id %d", protoPack.Id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no reply over unix socket")
+	}
+
+	server.Stop()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket file should be removed, got %v", err)
+	}
+}
+
+func TestResolveAbstractUnix(t *testing.T) {
+	addr, err := resolveAddr("unix://@socket-test")
+	if err != nil || addr.Network() != "unix" || !isAbstractUnix(addr.String()) {
+		t.Fatalf("unexpected addr %v, %v", addr, err)
+	}
+}
+
+func TestWithUmask(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no umask on windows")
+	}
+	path := filepath.Join(t.TempDir(), "file")
+	if err := withUmask(0600, func() error { return os.WriteFile(path, nil, 0666) }); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm&^0600 != 0 {
+		t.Fatalf("file created with %v, should not exceed 0600", perm)
+	}
+}
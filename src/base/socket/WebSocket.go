@@ -0,0 +1,515 @@
+package socket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket 数据帧的类型
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+const (
+	wsCloseTimeout  = time.Second      // 关闭时发送close 帧最多等待的时间
+	wsHeaderTimeout = 10 * time.Second // Config.WebSocketAddr 单独监听时读取HTTP 请求头的超时时间
+)
+
+var (
+	ErrWebSocketHandshake = errors.New("WebSocket 握手失败。")
+	ErrWebSocketProtocol  = errors.New("WebSocket 协议错误。")
+	ErrWebSocketText      = errors.New("WebSocket 不支持文本消息。")
+)
+
+/**
+ * WebSocket 传输层，实现ITransport。每次Flush 把缓存的数据作为一个二进制消息发出，
+ * Read 把收到的二进制消息拼成字节流，所以可以和FramedTransport、DefaultCodec 一起使用。
+ * ping 会自动回复pong，收到close 后Read 返回io.EOF，收到文本消息时回复1003 并返回ErrWebSocketText
+ * @author abram
+ */
+type WebSocket struct {
+	conn            net.Conn
+	reader          *bufio.Reader
+	timeout         time.Duration
+	client          bool        // 客户端发出的数据帧要加掩码
+	url             *url.URL    // 客户端连接的地址
+	tlsConfig       *tls.Config // wss 使用的TLS 配置
+	readInterrupted int32
+	closed          int32 // 关闭后conn 不再置为nil，其它协程仍可安全地读到conn
+
+	writeLock   sync.Mutex // 读协程回复pong 和写数据互斥
+	writeBuffer bytes.Buffer
+	closeSent   bool
+
+	remaining uint64  // 当前数据帧还没读的长度
+	masked    bool    // 当前数据帧是否有掩码
+	maskKey   [4]byte // 当前数据帧的掩码
+	maskPos   int
+}
+
+/**
+ * 根据ws:// 或wss:// 地址创建一个客户端WebSocket，调用Open 后才连接
+ * @author abram
+ * @param rawurl 地址，比如ws://127.0.0.1:8080/ws
+ * @param timeout 超时时间
+ * @param tlsConfig wss 使用的TLS 配置，可以为nil
+ * @return WebSocket
+ */
+func NewWebSocket(rawurl string, timeout time.Duration, tlsConfig *tls.Config) (*WebSocket, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return nil, errors.New("WebSocket 地址必须以ws:// 或wss:// 开头。")
+	}
+	return &WebSocket{url: u, timeout: timeout, tlsConfig: tlsConfig, client: true}, nil
+}
+
+/**
+ * 默认的Origin 检查：没有Origin 头(非浏览器客户端)或者Origin 的主机和请求的Host 相同时允许
+ * @author abram
+ * @param r 请求
+ * @return bool
+ */
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// 是否为WebSocket 地址
+func isWebSocketURL(addr string) bool {
+	return strings.HasPrefix(addr, "ws://") || strings.HasPrefix(addr, "wss://")
+}
+
+// 握手时的Sec-WebSocket-Accept
+func websocketAccept(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// header 中逗号分隔的值是否包含token，不区分大小写
+func headerContains(header http.Header, name, token string) bool {
+	for _, v := range header[http.CanonicalHeaderKey(name)] {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/**
+ * 把HTTP 请求升级为WebSocket 连接，失败时已经给客户端返回了错误
+ * @author abram
+ * @param w http.ResponseWriter，必须支持Hijack
+ * @param r 请求
+ * @param timeout 读写超时时间
+ * @return WebSocket
+ */
+func UpgradeWebSocket(w http.ResponseWriter, r *http.Request, timeout time.Duration) (*WebSocket, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || !headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") || key == "" {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return nil, ErrWebSocketHandshake
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Upgrade Required", http.StatusUpgradeRequired)
+		return nil, ErrWebSocketHandshake
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, errors.New("http.ResponseWriter 不支持Hijack。")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + websocketAccept(key) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return &WebSocket{conn: conn, reader: rw.Reader, timeout: timeout}, nil
+}
+
+// 客户端连接并握手
+func (socket *WebSocket) Open() error {
+	if socket.IsOpen() {
+		return nil
+	}
+	if socket.url == nil {
+		return errors.New("addr 为空。")
+	}
+
+	host := socket.url.Host
+	if socket.url.Port() == "" {
+		if socket.url.Scheme == "wss" {
+			host = net.JoinHostPort(socket.url.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(socket.url.Hostname(), "80")
+		}
+	}
+	dialer := &net.Dialer{Timeout: socket.timeout}
+	var conn net.Conn
+	var err error
+	if socket.url.Scheme == "wss" {
+		config := socket.tlsConfig
+		if config == nil {
+			config = &tls.Config{}
+		}
+		if config.ServerName == "" {
+			config = config.Clone()
+			config.ServerName = socket.url.Hostname()
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, config)
+	} else {
+		conn, err = dialer.Dial("tcp", host)
+	}
+	if err != nil {
+		return err
+	}
+	if err := socket.handshake(conn); err != nil {
+		conn.Close()
+		return err
+	}
+	return nil
+}
+
+// 客户端握手
+func (socket *WebSocket) handshake(conn net.Conn) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{Path: socket.url.Path, RawQuery: socket.url.RawQuery},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       socket.url.Host,
+	}
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+
+	if socket.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(socket.timeout))
+	}
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != websocketAccept(key) {
+		return ErrWebSocketHandshake
+	}
+	conn.SetDeadline(time.Time{})
+	socket.conn = conn
+	socket.reader = reader
+	atomic.StoreInt32(&socket.closed, 0)
+	return nil
+}
+
+// 使用超时机制
+func (socket *WebSocket) pushDeadline(read, write bool) {
+	var t time.Time
+	if socket.timeout > 0 {
+		t = time.Now().Add(socket.timeout)
+	}
+	if read {
+		socket.conn.SetReadDeadline(t)
+	}
+	if write {
+		socket.conn.SetWriteDeadline(t)
+	}
+}
+
+func (socket *WebSocket) IsOpen() bool {
+	return socket.conn != nil && atomic.LoadInt32(&socket.closed) == 0
+}
+
+func (socket *WebSocket) Peek() bool {
+	return socket.IsOpen()
+}
+
+// 获取net.Conn
+func (socket *WebSocket) Conn() net.Conn {
+	return socket.conn
+}
+
+// 读取二进制消息的数据
+func (socket *WebSocket) Read(buf []byte) (int, error) {
+	if !socket.IsOpen() {
+		return 0, ErrConnectionClosed
+	}
+	for socket.remaining == 0 {
+		socket.pushDeadline(true, false)
+		if atomic.LoadInt32(&socket.readInterrupted) == 1 {
+			return 0, errReadInterrupted
+		}
+		if err := socket.nextFrame(); err != nil {
+			if atomic.LoadInt32(&socket.readInterrupted) == 1 {
+				return 0, errReadInterrupted
+			}
+			return 0, err
+		}
+	}
+
+	if uint64(len(buf)) > socket.remaining {
+		buf = buf[:socket.remaining]
+	}
+	n, err := socket.reader.Read(buf)
+	if socket.masked {
+		for i := 0; i < n; i++ {
+			buf[i] ^= socket.maskKey[socket.maskPos&3]
+			socket.maskPos++
+		}
+	}
+	socket.remaining -= uint64(n)
+	if err == io.EOF && socket.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// 读取下一个数据帧的头，控制帧在这里处理完
+func (socket *WebSocket) nextFrame() error {
+	var header [2]byte
+	if _, err := io.ReadFull(socket.reader, header[:]); err != nil {
+		return err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0f
+	if header[0]&0x70 != 0 {
+		return ErrWebSocketProtocol
+	}
+	masked := header[1]&0x80 != 0
+	// 客户端发来的帧必须有掩码，服务端发来的帧不能有掩码
+	if masked == socket.client {
+		return ErrWebSocketProtocol
+	}
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(socket.reader, ext[:]); err != nil {
+			return err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(socket.reader, ext[:]); err != nil {
+			return err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	var maskKey [4]byte
+	if masked {
+		if _, err := io.ReadFull(socket.reader, maskKey[:]); err != nil {
+			return err
+		}
+	}
+
+	switch opcode {
+	case wsText:
+		// 数据是二进制的字节流，文本消息当作二进制处理会打乱数据帧
+		socket.writeLock.Lock()
+		if !socket.closeSent {
+			socket.closeSent = true
+			// 1003 表示不支持的数据类型
+			socket.writeFrame(wsClose, []byte{0x03, 0xeb})
+		}
+		socket.writeLock.Unlock()
+		return ErrWebSocketText
+	case wsContinuation, wsBinary:
+		socket.remaining = length
+		socket.masked = masked
+		socket.maskKey = maskKey
+		socket.maskPos = 0
+		return nil
+	case wsClose, wsPing, wsPong:
+		if !fin || length > 125 {
+			return ErrWebSocketProtocol
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(socket.reader, payload); err != nil {
+			return err
+		}
+		if masked {
+			for i := range payload {
+				payload[i] ^= maskKey[i&3]
+			}
+		}
+		switch opcode {
+		case wsPing:
+			socket.writeLock.Lock()
+			err := socket.writeFrame(wsPong, payload)
+			socket.writeLock.Unlock()
+			return err
+		case wsClose:
+			socket.writeLock.Lock()
+			if !socket.closeSent {
+				socket.closeSent = true
+				socket.writeFrame(wsClose, payload)
+			}
+			socket.writeLock.Unlock()
+			return io.EOF
+		}
+		return nil
+	}
+	return ErrWebSocketProtocol
+}
+
+// 写一个完整的数据帧，调用前要持有writeLock
+func (socket *WebSocket) writeFrame(opcode byte, payload []byte) error {
+	frame, err := socket.encodeFrame(opcode, payload)
+	if err != nil {
+		return err
+	}
+	socket.pushDeadline(false, true)
+	_, err = socket.conn.Write(frame)
+	return err
+}
+
+// 生成一个完整的数据帧，客户端加掩码
+func (socket *WebSocket) encodeFrame(opcode byte, payload []byte) ([]byte, error) {
+	frame := make([]byte, 0, len(payload)+14)
+	frame = append(frame, 0x80|opcode)
+	var maskBit byte
+	if socket.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBit|126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = append(frame, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(frame[len(frame)-8:], uint64(n))
+	}
+	if socket.client {
+		var maskKey [4]byte
+		if _, err := rand.Read(maskKey[:]); err != nil {
+			return nil, err
+		}
+		frame = append(frame, maskKey[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		for i := range payload {
+			frame[start+i] ^= maskKey[i&3]
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	return frame, nil
+}
+
+// 写入缓存，Flush 时作为一个二进制消息发出
+func (socket *WebSocket) Write(buf []byte) (int, error) {
+	if !socket.IsOpen() {
+		return 0, errors.New("WebSocket 连接已关闭。")
+	}
+	socket.writeLock.Lock()
+	defer socket.writeLock.Unlock()
+	return socket.writeBuffer.Write(buf)
+}
+
+func (socket *WebSocket) Flush() error {
+	if !socket.IsOpen() {
+		return errors.New("WebSocket 连接已关闭。")
+	}
+	socket.writeLock.Lock()
+	defer socket.writeLock.Unlock()
+	if socket.writeBuffer.Len() == 0 {
+		return nil
+	}
+	err := socket.writeFrame(wsBinary, socket.writeBuffer.Bytes())
+	socket.writeBuffer.Reset()
+	return err
+}
+
+// 发送close 帧后关闭连接，可以被多个协程同时调用，只有第一次真正关闭。
+// close 帧尽力发送：其它协程正在写时不等它（可能卡在不读数据的对方上），最多等wsCloseTimeout
+func (socket *WebSocket) Close() error {
+	if socket.conn == nil || !atomic.CompareAndSwapInt32(&socket.closed, 0, 1) {
+		return nil
+	}
+	if socket.writeLock.TryLock() {
+		if !socket.closeSent {
+			socket.closeSent = true
+			// 1000 表示正常关闭
+			if frame, err := socket.encodeFrame(wsClose, []byte{0x03, 0xe8}); err == nil {
+				socket.conn.SetWriteDeadline(time.Now().Add(wsCloseTimeout))
+				socket.conn.Write(frame)
+			}
+		}
+		socket.writeLock.Unlock()
+	}
+	return socket.conn.Close()
+}
+
+// 中断连接
+func (socket *WebSocket) Interrupt() error {
+	if !socket.IsOpen() {
+		return nil
+	}
+	return socket.conn.Close()
+}
+
+// 中断读操作，正在阻塞的Read 会立即返回，写操作仍然可用
+func (socket *WebSocket) InterruptRead() error {
+	if !socket.IsOpen() {
+		return nil
+	}
+	atomic.StoreInt32(&socket.readInterrupted, 1)
+	return socket.conn.SetReadDeadline(time.Now())
+}
@@ -0,0 +1,145 @@
+package socket
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestWebSocketAccept(t *testing.T) {
+	// RFC 6455 1.3 中的例子
+	if got := websocketAccept("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected accept %q", got)
+	}
+}
+
+func TestWebSocketEcho(t *testing.T) {
+	wsAddr := freeAddr(t)
+	srvConfig := newTestConfig(freeAddr(t))
+	srvConfig.WebSocketAddr = wsAddr
+	srvConfig.WebSocketPath = "/ws"
+	srvConfig.MessageHandler = func(channel IChannel, protoPack *ProtoPack) {
+		channel.Write(ProtoPack{Id: protoPack.Id, Body: append([]byte("re:"), protoPack.Body...)})
+	}
+	server := startTestServer(t, srvConfig)
+	defer server.Stop()
+
+	received := make(chan *ProtoPack, 1)
+	cliConfig := newTestConfig("ws://" + wsAddr + "/ws")
+	cliConfig.MessageHandler = func(channel IChannel, protoPack *ProtoPack) { received <- protoPack }
+	client, err := NewClient(cliConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// 超过125 字节，使用16 位的长度
+	body := make([]byte, 300)
+	if err := client.Write(ProtoPack{Id: 3, Body: body}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case protoPack := <-received:
+		if protoPack.Id != 3 || len(protoPack.Body) != 303 || server.ChannelCount() != 1 {
+			t.Fatalf("unexpected pack id=%d len=%d", protoPack.Id, len(protoPack.Body))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no reply over WebSocket")
+	}
+}
+
+// 用原始的HTTP 请求握手，返回连接和握手响应
+func rawWebSocketDial(t *testing.T, addr, origin string) (net.Conn, *bufio.Reader, *http.Response) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://"+addr+"/", nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	req.Write(conn)
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, reader, resp
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	wsAddr := freeAddr(t)
+	srvConfig := newTestConfig(freeAddr(t))
+	srvConfig.WebSocketAddr = wsAddr
+	server := startTestServer(t, srvConfig)
+	defer server.Stop()
+
+	for origin, status := range map[string]int{
+		"":                    http.StatusSwitchingProtocols,
+		"http://" + wsAddr:    http.StatusSwitchingProtocols,
+		"http://evil.example": http.StatusForbidden,
+	} {
+		conn, _, resp := rawWebSocketDial(t, wsAddr, origin)
+		conn.Close()
+		if resp.StatusCode != status {
+			t.Errorf("origin %q: expected %d, got %d", origin, status, resp.StatusCode)
+		}
+	}
+
+	// 文本消息被拒绝，服务端回复1003
+	conn, reader, resp := rawWebSocketDial(t, wsAddr, "")
+	defer conn.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake failed: %d", resp.StatusCode)
+	}
+	conn.Write([]byte{0x81, 0x82, 0, 0, 0, 0, 'h', 'i'})
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	frame := make([]byte, 4)
+	if _, err := io.ReadFull(reader, frame); err != nil {
+		t.Fatal(err)
+	}
+	if frame[0] != 0x80|wsClose || frame[2] != 0x03 || frame[3] != 0xeb {
+		t.Fatalf("expected close 1003, got % x", frame)
+	}
+}
+
+func TestWebSocketCloseWhileWriteBlocked(t *testing.T) {
+	local, peer := net.Pipe()
+	defer peer.Close()
+	socket := &WebSocket{conn: local, reader: bufio.NewReader(local)}
+
+	// 对方不读数据，Flush 一直卡在写上并持有writeLock
+	socket.Write([]byte("stalled"))
+	flushed := make(chan error, 1)
+	go func() { flushed <- socket.Flush() }()
+	time.Sleep(20 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		socket.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked behind a stalled write")
+	}
+	select {
+	case err := <-flushed:
+		if err == nil {
+			t.Fatal("stalled Flush should fail after Close")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stalled Flush not released by Close")
+	}
+}
@@ -0,0 +1,217 @@
+package socket
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrWriteQueueFull = errors.New("写队列已满。")
+
+// 写队列满了之后的处理方式
+type WriteQueuePolicy int
+
+const (
+	WriteBlock WriteQueuePolicy = iota // 等待队列有空位
+	WriteDrop                          // 丢弃这个数据包，Write 返回ErrWriteQueueFull
+	WriteClose                         // 关闭连接，Write 返回ErrWriteQueueFull
+)
+
+/**
+ * 异步写队列配置。每个连接一个写协程，Write 只把数据包放进队列，
+ * 写协程把队列中的多个数据帧合并后一次写给socket。
+ * 默认不使用写队列，Write 在调用的协程中持有连接的锁直接写socket，
+ * 并发写同一个连接时会互相等待，数据帧也不合并
+ * @author abram
+ */
+type WriteQueueConfig struct {
+	Size         int              // 队列长度
+	Policy       WriteQueuePolicy // 队列满了之后的处理方式
+	MaxBatchSize int              // 一次最多合并多少字节，不可靠的UDP 连接一个数据帧一个数据报，不合并
+}
+
+/**
+ * 生成默认的写队列配置
+ * @author abram
+ * @return WriteQueueConfig
+ */
+func NewWriteQueueConfig() *WriteQueueConfig {
+	return &WriteQueueConfig{Size: 1024, Policy: WriteBlock, MaxBatchSize: 32 << 10}
+}
+
+// 可以合并写的传输层，FramedTransport 实现了这个接口
+type batchWriter interface {
+	beginBatch()
+	batchSize() int
+	endBatch() error
+}
+
+// 每个数据帧必须单独写出的传输层，比如不可靠的UDP，合并后可能超过数据报的最大长度
+type framePerWriter interface {
+	framePerWrite() bool
+}
+
+// 队列中的数据包或已经编码好的数据帧
+type writeItem struct {
+	protoPack ProtoPack
+	frame     []byte
+}
+
+// 一个连接的写队列
+type writeQueue struct {
+	channel      *DefaultChannel
+	items        chan writeItem
+	policy       WriteQueuePolicy
+	maxBatchSize int // 为0 时不合并写
+
+	stopOnce sync.Once
+	quit     chan struct{}
+	exited   chan struct{}
+	drain    bool // 退出前把队列中的数据写完
+}
+
+// 启动连接的写协程
+func (channel *DefaultChannel) startWriteQueue(config *WriteQueueConfig) {
+	if config == nil {
+		return
+	}
+	defaults := NewWriteQueueConfig()
+	q := &writeQueue{
+		channel:      channel,
+		items:        make(chan writeItem, config.Size),
+		policy:       config.Policy,
+		maxBatchSize: config.MaxBatchSize,
+		quit:         make(chan struct{}),
+		exited:       make(chan struct{}),
+	}
+	if config.Size <= 0 {
+		q.items = make(chan writeItem, defaults.Size)
+	}
+	if q.maxBatchSize <= 0 {
+		q.maxBatchSize = defaults.MaxBatchSize
+	}
+	if v, ok := channel.socket.(framePerWriter); ok && v.framePerWrite() {
+		q.maxBatchSize = 0
+	}
+	channel.writeQueue = q
+	go q.loop()
+}
+
+// 放入队列
+func (q *writeQueue) put(item writeItem) error {
+	select {
+	case <-q.quit:
+		return ErrChannelClosed
+	default:
+	}
+
+	switch q.policy {
+	case WriteDrop, WriteClose:
+		select {
+		case q.items <- item:
+			return nil
+		default:
+		}
+		if q.policy == WriteClose {
+			q.channel.Close()
+		}
+		return ErrWriteQueueFull
+	default:
+		select {
+		case q.items <- item:
+			return nil
+		case <-q.quit:
+			return ErrChannelClosed
+		}
+	}
+}
+
+func (q *writeQueue) loop() {
+	defer close(q.exited)
+	for {
+		select {
+		case item := <-q.items:
+			if err := q.write(item); err != nil {
+				// 连接已经不能写了，关闭后读协程会退出
+				q.stop(false)
+				q.channel.codec.Close()
+				return
+			}
+		case <-q.quit:
+			if q.drain {
+				for {
+					select {
+					case item := <-q.items:
+						if q.write(item) != nil {
+							return
+						}
+					default:
+						return
+					}
+				}
+			}
+			return
+		}
+	}
+}
+
+// 写一个数据包，并把队列中已有的数据包合并在一起写出去
+func (q *writeQueue) write(item writeItem) error {
+	channel := q.channel
+	channel.writeLock.Lock()
+	defer channel.writeLock.Unlock()
+
+	var batcher batchWriter
+	if q.maxBatchSize > 0 {
+		batcher, _ = channel.framed.(batchWriter)
+	}
+	if batcher != nil {
+		batcher.beginBatch()
+	}
+	err := q.writeItem(item)
+	active := item.frame != nil || !isHeartbeat(item.protoPack.Id)
+	for err == nil && batcher != nil && batcher.batchSize() < q.maxBatchSize {
+		select {
+		case item = <-q.items:
+			err = q.writeItem(item)
+			active = active || item.frame != nil || !isHeartbeat(item.protoPack.Id)
+			continue
+		default:
+		}
+		break
+	}
+	if batcher != nil {
+		if endErr := batcher.endBatch(); err == nil {
+			err = endErr
+		}
+	}
+	if err == nil && active {
+		atomic.StoreInt64(&channel.lastWrite, time.Now().UnixNano())
+	}
+	return err
+}
+
+func (q *writeQueue) writeItem(item writeItem) error {
+	if item.frame != nil {
+		if _, err := q.channel.framed.Write(item.frame); err != nil {
+			return err
+		}
+		return q.channel.framed.Flush()
+	}
+	return q.channel.codec.Encode(item.protoPack)
+}
+
+// 停止写协程，drain 为true 时先把队列中的数据写完
+func (q *writeQueue) stop(drain bool) {
+	q.stopOnce.Do(func() {
+		q.drain = drain
+		close(q.quit)
+	})
+}
+
+// 停止写协程并等待退出
+func (q *writeQueue) close(drain bool) {
+	q.stop(drain)
+	<-q.exited
+}
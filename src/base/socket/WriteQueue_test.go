@@ -0,0 +1,130 @@
+package socket
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+// 记录Flush 次数的内存传输层
+type countingTransport struct {
+	memoryTransport
+	lock    sync.Mutex
+	flushes int
+	gate    chan struct{} // 不为nil 时Write 要等gate
+}
+
+func (transport *countingTransport) Write(buf []byte) (int, error) {
+	if transport.gate != nil {
+		<-transport.gate
+	}
+	transport.lock.Lock()
+	defer transport.lock.Unlock()
+	return transport.memoryTransport.Write(buf)
+}
+
+func (transport *countingTransport) Flush() error {
+	transport.lock.Lock()
+	defer transport.lock.Unlock()
+	transport.flushes++
+	return nil
+}
+
+func TestWriteQueueConcurrent(t *testing.T) {
+	transport := &countingTransport{}
+	framed := NewFramedTransport(transport)
+	channel := newDefaultChannel(transport, framed, NewDefaultCodec(framed))
+	channel.startWriteQueue(NewWriteQueueConfig())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(id int16) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				channel.Write(ProtoPack{Id: id, Body: bytes.Repeat([]byte{byte(id)}, 100)})
+			}
+		}(int16(i))
+	}
+	wg.Wait()
+	channel.FlushAndClose()
+
+	if transport.flushes >= 1000 {
+		t.Fatalf("frames were not batched, %d flushes", transport.flushes)
+	}
+	codec := NewDefaultCodec(NewFramedTransport(&transport.memoryTransport))
+	for i := 0; i < 1000; i++ {
+		protoPack, err := codec.Decode()
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if !bytes.Equal(protoPack.Body, bytes.Repeat([]byte{byte(protoPack.Id)}, 100)) {
+			t.Fatalf("frame %d corrupted", i)
+		}
+	}
+}
+
+func TestWriteQueueDrop(t *testing.T) {
+	transport := &countingTransport{gate: make(chan struct{})}
+	framed := NewFramedTransport(transport)
+	channel := newDefaultChannel(transport, framed, NewDefaultCodec(framed))
+	channel.startWriteQueue(&WriteQueueConfig{Size: 1, Policy: WriteDrop})
+	defer close(transport.gate)
+	defer channel.Close()
+
+	var err error
+	// 写协程阻塞在第一个数据包上，队列里放一个，之后的要被丢弃
+	for i := 0; i < 10 && err == nil; i++ {
+		err = channel.Write(ProtoPack{Id: 1})
+	}
+	if err != ErrWriteQueueFull {
+		t.Fatalf("expected ErrWriteQueueFull, got %v", err)
+	}
+}
+
+func TestWriteQueueUnreliableUDP(t *testing.T) {
+	gate := make(chan struct{})
+	var lock sync.Mutex
+	var sizes []int
+	send := func(packet []byte) error {
+		<-gate
+		if packet[0] != udpData {
+			return nil
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		sizes = append(sizes, len(packet))
+		return nil
+	}
+	session := newUDPSession(1, NewUDPConfig(), send, func() {})
+	defer session.Close()
+	framed := NewFramedTransport(session)
+	channel := newDefaultChannel(session, framed, NewDefaultCodec(framed))
+	config := NewWriteQueueConfig()
+	config.MaxBatchSize = 1 << 20
+	channel.startWriteQueue(config)
+
+	// 第一个数据报发出前其它数据包都在队列里，不能合并成超过数据报长度的一批
+	for i := 0; i < 8; i++ {
+		if err := channel.Write(ProtoPack{Id: int16(i), Body: bytes.Repeat([]byte{1}, 16<<10)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(gate)
+	channel.FlushAndClose()
+
+	lock.Lock()
+	defer lock.Unlock()
+	frames := 0
+	for _, size := range sizes {
+		if size > maxUDPDatagramSize {
+			t.Fatalf("datagram of %d bytes exceeds the UDP limit", size)
+		}
+		if size > 16<<10 {
+			frames++
+		}
+	}
+	if frames != 8 {
+		t.Fatalf("expected one datagram per frame, got %v", sizes)
+	}
+}
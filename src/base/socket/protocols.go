@@ -6,9 +6,10 @@ package socket
  */
 type ProtoPack struct {
 	Id           int16  //消息id
-	Iscompressed byte   // 是否压缩 0-未压缩 1-压缩
-	Isencrypted  byte   // 是否加密 0-未加密 1-加密
+	Iscompressed byte   // 压缩算法 0-未压缩，其它值为RegisterCompressor 注册的编号，1-gzip
+	Isencrypted  byte   // 加密算法 0-未加密，其它值为RegisterCipher 注册的编号，1-AES-GCM 2-ChaCha20-Poly1305
 	PlatformId   byte   // 平台号
+	Seq          uint32 // 请求序号，只有使用NewSequencedCodecFactory时才会写入数据流
 	Body         []byte // 消息体
 }
 